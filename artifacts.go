@@ -0,0 +1,375 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/deb"
+	"pault.ag/go/debian/version"
+)
+
+const (
+	// artifactSizeThreshold is the relative size change of a file or
+	// package which is considered significant.
+	artifactSizeThreshold = 0.1
+	// artifactMinSizeChange is the minimum size change in bytes of a file
+	// which is considered significant.
+	artifactMinSizeChange = 4096
+)
+
+// binNMUSuffixRe matches the version suffix of binNMUs, e.g. “+b1”.
+var binNMUSuffixRe = regexp.MustCompile(`^\+b[0-9]+$`)
+
+// builtFromVersion reports whether a binary package at binVersion was built
+// from the source at srcVersion, possibly as a binNMU.
+func builtFromVersion(binVersion, srcVersion string) bool {
+	suffix, ok := strings.CutPrefix(binVersion, srcVersion)
+	return ok && (suffix == "" || binNMUSuffixRe.MatchString(suffix))
+}
+
+// ignoredControlFields are expected to differ between builds.
+var ignoredControlFields = map[string]bool{
+	"Version":        true,
+	"Source":         true,
+	"Installed-Size": true,
+}
+
+// artifactDiff lists the significant differences between a binary package
+// produced by a rebuild and its reference (baseline build or archive).
+type artifactDiff struct {
+	Package string `json:"package"`
+	// Missing is set if the reference contains the package, but the rebuild
+	// did not produce it; Added for the opposite.
+	Missing      bool     `json:"missing,omitempty"`
+	Added        bool     `json:"added,omitempty"`
+	Fields       []string `json:"fields,omitempty"`
+	FilesAdded   []string `json:"files_added,omitempty"`
+	FilesRemoved []string `json:"files_removed,omitempty"`
+	SizeChanges  []string `json:"size_changes,omitempty"`
+	Diffoscope   string   `json:"diffoscope,omitempty"`
+}
+
+func (d *artifactDiff) empty() bool {
+	return !d.Missing && !d.Added && len(d.Fields) == 0 && len(d.FilesAdded) == 0 &&
+		len(d.FilesRemoved) == 0 && len(d.SizeChanges) == 0
+}
+
+// debInfo is the control file and file list of a .deb.
+type debInfo struct {
+	control map[string]string
+	// files maps paths to their size. Symbolic links are listed as
+	// “path -> target”.
+	files map[string]int64
+}
+
+func readDebInfo(path string) (*debInfo, error) {
+	d, closer, err := deb.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	info := &debInfo{
+		control: make(map[string]string),
+		files:   make(map[string]int64),
+	}
+	for _, key := range d.Control.Order {
+		info.control[key] = strings.TrimSpace(d.Control.Values[key])
+	}
+	for {
+		hdr, err := d.Data.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(hdr.Name, ".")
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			info.files[name] = hdr.Size
+		case tar.TypeSymlink:
+			info.files[name+" -> "+hdr.Linkname] = 0
+		}
+	}
+	return info, nil
+}
+
+// normalizeBuiltUsing drops the versions of ourSources from a Built-Using
+// field, since those are expected to change.
+func normalizeBuiltUsing(value string, ourSources map[string]bool) string {
+	var rels []string
+	for _, rel := range strings.Split(value, ",") {
+		rel = strings.TrimSpace(rel)
+		if name := strings.Fields(rel); len(name) > 0 && ourSources[name[0]] {
+			rel = name[0]
+		}
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	return strings.Join(rels, ", ")
+}
+
+func significantSizeChange(old, new int64, minChange int64) bool {
+	delta := new - old
+	if delta < 0 {
+		delta = -delta
+	}
+	return delta >= minChange && float64(delta) > artifactSizeThreshold*float64(max(old, 1))
+}
+
+// compareDebInfo returns the significant differences between ref and built.
+func compareDebInfo(pkg string, ref, built *debInfo, ourSources map[string]bool) *artifactDiff {
+	diff := &artifactDiff{Package: pkg}
+	fields := make(map[string]bool)
+	for key := range ref.control {
+		fields[key] = true
+	}
+	for key := range built.control {
+		fields[key] = true
+	}
+	for _, key := range sortedKeys(fields) {
+		if ignoredControlFields[key] {
+			continue
+		}
+		old, new := ref.control[key], built.control[key]
+		if key == "Built-Using" || key == "Static-Built-Using" {
+			old, new = normalizeBuiltUsing(old, ourSources), normalizeBuiltUsing(new, ourSources)
+		}
+		if old != new {
+			diff.Fields = append(diff.Fields, fmt.Sprintf("%s: %q -> %q", key, old, new))
+		}
+	}
+	oldSize, _ := strconv.ParseInt(ref.control["Installed-Size"], 10, 64)
+	newSize, _ := strconv.ParseInt(built.control["Installed-Size"], 10, 64)
+	if significantSizeChange(oldSize, newSize, 1) {
+		diff.SizeChanges = append(diff.SizeChanges, fmt.Sprintf("Installed-Size: %d KiB -> %d KiB", oldSize, newSize))
+	}
+
+	for _, file := range sortedKeys(built.files) {
+		oldFileSize, ok := ref.files[file]
+		if !ok {
+			diff.FilesAdded = append(diff.FilesAdded, file)
+		} else if significantSizeChange(oldFileSize, built.files[file], artifactMinSizeChange) {
+			diff.SizeChanges = append(diff.SizeChanges, fmt.Sprintf("%s: %d -> %d bytes", file, oldFileSize, built.files[file]))
+		}
+	}
+	for _, file := range sortedKeys(ref.files) {
+		if _, ok := built.files[file]; !ok {
+			diff.FilesRemoved = append(diff.FilesRemoved, file)
+		}
+	}
+	return diff
+}
+
+// changesDebs returns the .debs referenced by changesFile by package name.
+func changesDebs(changesFile string) (map[string]string, error) {
+	changes, err := control.ParseChangesFile(changesFile)
+	if err != nil {
+		return nil, err
+	}
+	debs := make(map[string]string)
+	for _, file := range changes.AbsFiles() {
+		if name, _, ok := debNameVersion(file.Filename); ok && strings.HasSuffix(file.Filename, ".deb") {
+			debs[name] = file.Filename
+		}
+	}
+	return debs, nil
+}
+
+// archiveDebCandidates returns, for each of names, the newest binary package
+// in pkgs which was built from src at srcVersion (or a binNMU of it).
+// Packages without such a binary are left out, rather than compared with an
+// unrelated upload.
+func archiveDebCandidates(names []string, pkgs []binPkg, src, srcVersion string) map[string]binPkg {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	newest := make(map[string]binPkg)
+	for _, pkg := range pkgs {
+		if !wanted[pkg.Package] || pkg.Source != src || !builtFromVersion(pkg.Version.String(), srcVersion) {
+			continue
+		}
+		if cur, ok := newest[pkg.Package]; !ok || version.Compare(pkg.Version, cur.Version) > 0 {
+			newest[pkg.Package] = pkg
+		}
+	}
+	return newest
+}
+
+// archiveDebs downloads the archive .debs of the given binary packages (see
+// archiveDebCandidates) from the mirror into dir and returns them by package
+// name.
+func archiveDebs(names []string, pkgs []binPkg, src, srcVersion, dir string) map[string]string {
+	debs := make(map[string]string)
+	for name, pkg := range archiveDebCandidates(names, pkgs, src, srcVersion) {
+		path, err := downloadArchiveDeb(pkg.Filename)
+		if err != nil {
+			log.Printf("Could not download %s_%s from the archive: %v\n", pkg.Package, pkg.Version, err)
+			continue
+		}
+		dest := filepath.Join(dir, filepath.Base(pkg.Filename))
+		if err := os.Rename(path, dest); err != nil {
+			log.Printf("Could not move %s: %v\n", path, err)
+			continue
+		}
+		debs[name] = dest
+	}
+	return debs
+}
+
+// runDiffoscope writes the diffoscope(1) text output for ref and built to
+// out.
+func runDiffoscope(ref, built, out string) error {
+	if _, err := exec.LookPath("diffoscope"); err != nil {
+		return fmt.Errorf("diffoscope(1) not found. Please install the diffoscope package: %w", err)
+	}
+	cmd := exec.Command("diffoscope", "--text", out, ref, built)
+	cmd.Stderr = os.Stderr
+	// diffoscope exits with 1 if the files differ.
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareArtifactsWith compares the .debs referenced by changesFile with the
+// reference .debs and returns the packages with significant differences.
+func compareArtifactsWith(src, changesFile string, refDebs map[string]string, ourSources map[string]bool, diffoscopeDir string) ([]artifactDiff, error) {
+	built, err := changesDebs(changesFile)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []artifactDiff
+	for _, pkg := range sortedKeys(built) {
+		refPath, ok := refDebs[pkg]
+		if !ok {
+			diffs = append(diffs, artifactDiff{Package: pkg, Added: true})
+			continue
+		}
+		ref, err := readDebInfo(refPath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := readDebInfo(built[pkg])
+		if err != nil {
+			return nil, err
+		}
+		diff := compareDebInfo(pkg, ref, info, ourSources)
+		if diff.empty() {
+			continue
+		}
+		if diffoscopeDir != "" {
+			out := filepath.Join(diffoscopeDir, src+"_"+pkg+".diffoscope")
+			if err := runDiffoscope(refPath, built[pkg], out); err != nil {
+				log.Printf("Could not run diffoscope for %s: %v\n", pkg, err)
+			} else {
+				diff.Diffoscope = out
+			}
+		}
+		diffs = append(diffs, *diff)
+	}
+	for _, pkg := range sortedKeys(refDebs) {
+		if _, ok := built[pkg]; !ok {
+			diffs = append(diffs, artifactDiff{Package: pkg, Missing: true})
+		}
+	}
+	return diffs, nil
+}
+
+// printArtifactDiffs logs the artifact differences of r.
+func (r *buildResult) printArtifactDiffs() {
+	for _, d := range r.artifactDiffs {
+		switch {
+		case d.Missing:
+			log.Printf("    %s: no longer built\n", d.Package)
+			continue
+		case d.Added:
+			log.Printf("    %s: newly built\n", d.Package)
+			continue
+		}
+		for _, field := range d.Fields {
+			log.Printf("    %s: %s\n", d.Package, field)
+		}
+		if len(d.FilesAdded) > 0 {
+			log.Printf("    %s: %d files added: %s\n", d.Package, len(d.FilesAdded), strings.Join(d.FilesAdded, " "))
+		}
+		if len(d.FilesRemoved) > 0 {
+			log.Printf("    %s: %d files removed: %s\n", d.Package, len(d.FilesRemoved), strings.Join(d.FilesRemoved, " "))
+		}
+		for _, change := range d.SizeChanges {
+			log.Printf("    %s: %s\n", d.Package, change)
+		}
+		if d.Diffoscope != "" {
+			log.Printf("    %s: see %s\n", d.Package, d.Diffoscope)
+		}
+	}
+}
+
+// compareArtifacts compares the .debs of all successful rebuilds with those
+// of the baseline build without the new packages (see buildBaselines) or, if
+// pkgs is non-nil, with the archive .debs from pkgs.
+func compareArtifacts(buildresults map[string]*buildResult, pkgs []binPkg, ourSources map[string]bool, diffoscopeDir string) {
+	for _, src := range sortedKeys(buildresults) {
+		result := buildresults[src]
+		if result.err != nil || result.changesFile == "" {
+			continue
+		}
+		var refDebs map[string]string
+		var archiveDir string
+		if pkgs == nil {
+			if result.baselineChangesFile == "" {
+				continue
+			}
+			debs, err := changesDebs(result.baselineChangesFile)
+			if err != nil {
+				log.Printf("Could not read %s: %v\n", result.baselineChangesFile, err)
+				continue
+			}
+			refDebs = debs
+		} else {
+			built, err := changesDebs(result.changesFile)
+			if err != nil {
+				log.Printf("Could not read %s: %v\n", result.changesFile, err)
+				continue
+			}
+			archiveDir, err = os.MkdirTemp("", "ratt-archive-"+src+"-")
+			if err != nil {
+				log.Fatal(err)
+			}
+			names := sortedKeys(built)
+			for _, pkg := range pkgs {
+				if pkg.Source == src && builtFromVersion(pkg.Version.String(), result.version.String()) && built[pkg.Package] == "" {
+					names = append(names, pkg.Package)
+				}
+			}
+			sort.Strings(names)
+			refDebs = archiveDebs(uniq(names), pkgs, src, result.version.String(), archiveDir)
+			if len(refDebs) == 0 {
+				log.Printf("No archive .debs of %s_%s found, not comparing artifacts\n", src, result.version)
+				continue
+			}
+		}
+		diffs, err := compareArtifactsWith(src, result.changesFile, refDebs, ourSources, diffoscopeDir)
+		if archiveDir != "" {
+			os.RemoveAll(archiveDir)
+		}
+		if err != nil {
+			log.Printf("Could not compare artifacts of %s: %v\n", src, err)
+			continue
+		}
+		result.artifactDiffs = diffs
+	}
+}
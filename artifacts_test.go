@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"pault.ag/go/debian/version"
+)
+
+func TestBuiltFromVersion(t *testing.T) {
+	for _, tt := range []struct {
+		bin, src string
+		want     bool
+	}{
+		{"1.0-1", "1.0-1", true},
+		{"1.0-1+b1", "1.0-1", true},
+		{"1.0-1+b12", "1.0-1", true},
+		{"1.0-10", "1.0-1", false},
+		{"1.0-1.1", "1.0-1", false},
+		{"1.0-1+deb12u1", "1.0-1", false},
+		{"1.0-1+b", "1.0-1", false},
+		{"1.0", "1.0-1", false},
+	} {
+		if got := builtFromVersion(tt.bin, tt.src); got != tt.want {
+			t.Errorf("builtFromVersion(%q, %q) = %v, want %v", tt.bin, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveDebCandidates(t *testing.T) {
+	ver := func(s string) version.Version {
+		v, err := version.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	pkgs := []binPkg{
+		{Package: "foo", Source: "foo", Version: ver("1.0-1"), Filename: "pool/main/f/foo/foo_1.0-1_amd64.deb"},
+		{Package: "foo", Source: "foo", Version: ver("1.0-1+b1"), Filename: "pool/main/f/foo/foo_1.0-1+b1_amd64.deb"},
+		// A newer upload, e.g. in another suite.
+		{Package: "foo", Source: "foo", Version: ver("1.0-2"), Filename: "pool/main/f/foo/foo_1.0-2_amd64.deb"},
+		{Package: "foo-data", Source: "foo", Version: ver("1.0-10"), Filename: "pool/main/f/foo/foo-data_1.0-10_all.deb"},
+		{Package: "foo-doc", Source: "foo", Version: ver("1.0-1"), Filename: "pool/main/f/foo/foo-doc_1.0-1_all.deb"},
+		{Package: "foo-utils", Source: "other", Version: ver("1.0-1"), Filename: "pool/main/o/other/foo-utils_1.0-1_amd64.deb"},
+	}
+	got := archiveDebCandidates([]string{"foo", "foo-data", "foo-utils"}, pkgs, "foo", "1.0-1")
+	want := map[string]string{"foo": "pool/main/f/foo/foo_1.0-1+b1_amd64.deb"}
+	if len(got) != len(want) {
+		t.Fatalf("archiveDebCandidates() = %v, want %v", got, want)
+	}
+	for name, filename := range want {
+		if got[name].Filename != filename {
+			t.Errorf("archiveDebCandidates()[%q] = %q, want %q", name, got[name].Filename, filename)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "log"
+
+// buildBaselines builds every successful package again without the new
+// packages, as reference for -compare-artifacts=baseline and -lintian.
+func buildBaselines(buildresults map[string]*buildResult, builder *sbuild) {
+	srcs := sortedKeys(buildresults)
+	for i, src := range srcs {
+		result := buildresults[src]
+		if result.err != nil {
+			continue
+		}
+		log.Printf("Building baseline %d of %d: %s\n", i+1, len(srcs), src)
+		baseline := builder.build(src, result.version)
+		result.baselineLogFile = baseline.logFile
+		if baseline.err != nil {
+			log.Printf("Baseline build of %s failed (see %s)\n", src, baseline.logFile)
+			continue
+		}
+		result.baselineUsage = &baseline.usage
+		result.baselineChangesFile = baseline.changesFile
+	}
+}
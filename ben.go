@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// benRegexp returns a ben regular expression matching any of names as a whole
+// package name.
+func benRegexp(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	if len(quoted) == 1 {
+		return `/\b` + quoted[0] + `\b/`
+	}
+	return `/\b(` + strings.Join(quoted, "|") + `)\b/`
+}
+
+// benNotes summarizes the build results of the current run as the expected
+// good/bad status of the affected packages.
+func benNotes(buildresults map[string]*buildResult, dryRun bool) string {
+	if dryRun || len(buildresults) == 0 {
+		return "Generated by ratt; no build results available."
+	}
+	var good, bad, unrelated []string
+	for src, result := range buildresults {
+		switch {
+		case result.err == nil:
+			good = append(good, src)
+		case result.recheckErr != nil:
+			unrelated = append(unrelated, src)
+		case result.failureCategory != "":
+			bad = append(bad, src+" ("+result.failureCategory+")")
+		default:
+			bad = append(bad, src)
+		}
+	}
+	sort.Strings(good)
+	sort.Strings(bad)
+	sort.Strings(unrelated)
+	notes := fmt.Sprintf("Generated by ratt. Expected good (built fine): %s. Expected bad (failed with the new package): %s.",
+		strings.Join(good, " "), strings.Join(bad, " "))
+	if len(unrelated) > 0 {
+		notes += fmt.Sprintf(" Failing regardless of the new package: %s.", strings.Join(unrelated, " "))
+	}
+	return notes
+}
+
+// writeBenFile writes a transition tracker definition for ben(1), as used by
+// the release team, to path.
+func writeBenFile(path string, t *libTransition, buildresults map[string]*buildResult, dryRun bool) error {
+	title := t.Source
+	if t.OldVersion != "" {
+		title = fmt.Sprintf("%s (%s -> %s)", t.Source, t.OldVersion, t.NewVersion)
+	}
+	affected := append(append([]string{}, t.Old...), t.New...)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "title = %q;\n", title)
+	fmt.Fprintf(&b, "is_affected = .depends ~ %s;\n", benRegexp(affected))
+	fmt.Fprintf(&b, "is_good = .depends ~ %s;\n", benRegexp(t.New))
+	fmt.Fprintf(&b, "is_bad = .depends ~ %s;\n", benRegexp(t.Old))
+	fmt.Fprintf(&b, "notes = %q;\n", benNotes(buildresults, dryRun))
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// writeBenFileFlag writes the -ben file, if requested.
+func writeBenFileFlag(t *libTransition, buildresults map[string]*buildResult, dryRun bool) {
+	if *benFile == "" || t == nil {
+		return
+	}
+	if err := writeBenFile(*benFile, t, buildresults, dryRun); err != nil {
+		log.Printf("Could not write ben file %s: %v\n", *benFile, err)
+		return
+	}
+	log.Printf("Wrote ben transition tracker definition to %s\n", *benFile)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
+	"pault.ag/go/debian/version"
+)
+
+// expectedBreaks cross-checks the Breaks and Conflicts of the new binaries
+// against the binaries of the sources in rebuild (at their version from the
+// Sources index). Sources which are affected are expected to fail until they
+// are updated; the returned map explains why.
+func expectedBreaks(sources []control.SourceIndex, newBins []binPkg, rebuild map[string][]version.Version) map[string]string {
+	expected := make(map[string]string)
+	for _, src := range sources {
+		versions, ok := rebuild[src.Package]
+		if !ok || src.Version.String() != newestVersion(versions).String() {
+			continue
+		}
+		binaries := make(map[string]bool)
+		for _, bin := range src.Binaries {
+			binaries[strings.TrimSpace(bin)] = true
+		}
+		for _, bin := range newBins {
+			for field, dep := range map[string]dependency.Dependency{
+				"Breaks":    bin.Breaks,
+				"Conflicts": bin.Conflicts,
+			} {
+				for _, p := range dep.GetAllPossibilities() {
+					if !binaries[p.Name] {
+						continue
+					}
+					// Binary versions usually match the source version.
+					if p.Version != nil && !p.Version.SatisfiedBy(src.Version) {
+						continue
+					}
+					expected[src.Package] = fmt.Sprintf("%s %s: %s", bin.Package, field, p)
+				}
+			}
+		}
+	}
+	return expected
+}
+
+// newestVersion returns the highest of versions.
+func newestVersion(versions []version.Version) version.Version {
+	newest := versions[0]
+	for _, v := range versions[1:] {
+		if version.Compare(v, newest) > 0 {
+			newest = v
+		}
+	}
+	return newest
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/version"
+)
+
+// droppedBinaries returns the binaries which the archive version of
+// changesSources builds, but which are missing from the .changes files, i.e.
+// binaries that were dropped or renamed. archAll and archAny specify whether
+// the .changes files contain architecture-independent and -dependent
+// binaries, respectively: binaries of a kind which was not built at all are
+// not considered dropped.
+func droppedBinaries(sources []control.SourceIndex, changesSources, binaries []string, archAll, archAny bool) []string {
+	old, _ := archiveBinaries(sources, changesSources)
+	isArchAll := packageListArchAll(sources, changesSources)
+	current := make(map[string]bool)
+	for _, bin := range binaries {
+		current[bin] = true
+	}
+	var dropped []string
+	for bin := range old {
+		if current[bin] {
+			continue
+		}
+		if all, ok := isArchAll[bin]; ok && (all && !archAll || !all && !archAny) {
+			continue
+		}
+		dropped = append(dropped, bin)
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+// packageListArchAll reads the Package-List field of the given sources and
+// returns whether each binary is Architecture: all.
+func packageListArchAll(sources []control.SourceIndex, names []string) map[string]bool {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	result := make(map[string]bool)
+	for _, src := range sources {
+		if !wanted[src.Package] {
+			continue
+		}
+		// Each line looks like “foo deb libs optional arch=any”.
+		for _, line := range strings.Split(src.Values["Package-List"], "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 5 {
+				continue
+			}
+			for _, field := range fields[4:] {
+				if strings.HasPrefix(field, "arch=") {
+					result[fields[0]] = field == "arch=all"
+				}
+			}
+		}
+	}
+	return result
+}
+
+// certainFailures returns, for each source in rebuild, why it cannot be built
+// anymore: a build dependency which was only satisfiable by a dropped binary.
+func certainFailures(sources []control.SourceIndex, pkgs, newBins []binPkg, dropped []string, rebuild map[string][]version.Version, arch string) map[string]string {
+	droppedNames := make(map[string]bool)
+	for _, bin := range dropped {
+		droppedNames[bin] = true
+	}
+	u := newUniverse(arch, pkgs)
+	u.remove(func(pkg binPkg) bool {
+		return droppedNames[pkg.Package]
+	})
+	for _, bin := range newBins {
+		u.add(bin)
+	}
+
+	newest := make(map[string]control.SourceIndex)
+	for _, src := range sources {
+		if _, ok := rebuild[src.Package]; !ok {
+			continue
+		}
+		if cur, ok := newest[src.Package]; !ok || version.Compare(src.Version, cur.Version) > 0 {
+			newest[src.Package] = src
+		}
+	}
+
+	certain := make(map[string]string)
+	for name, src := range newest {
+		for _, rel := range u.unsatisfied(buildDependsOf(src)) {
+			for _, p := range rel.Possibilities {
+				if droppedNames[p.Name] {
+					certain[name] = fmt.Sprintf("build-depends on %s, which is no longer built", rel)
+					break
+				}
+			}
+			if _, ok := certain[name]; ok {
+				break
+			}
+		}
+	}
+	return certain
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// packageChange describes a package whose version differs between the
+// baseline (-recheck) build environment and the one with the new packages.
+// OldVersion is empty for packages which were added, NewVersion for packages
+// which were removed.
+type packageChange struct {
+	Package    string `json:"package"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+	// Ours is set for binaries from the .changes files.
+	Ours bool `json:"ours,omitempty"`
+}
+
+func (c packageChange) String() string {
+	s := c.Package + " "
+	switch {
+	case c.OldVersion == "":
+		s += "(added " + c.NewVersion + ")"
+	case c.NewVersion == "":
+		s += "(removed " + c.OldVersion + ")"
+	default:
+		s += c.OldVersion + " -> " + c.NewVersion
+	}
+	if c.Ours {
+		s += " [ours]"
+	}
+	return s
+}
+
+// installedPackages parses the “Package versions” section of an sbuild log,
+// which lists the packages installed in the chroot at build time either as
+// “name_version_arch” or, with older sbuild versions, in dpkg -l format.
+func installedPackages(logFile string) (map[string]string, error) {
+	lines, err := readLines(logFile)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]string)
+	inSection := false
+	for _, line := range lines {
+		// Section headers are boxed (“| Package versions   |”); dpkg -l
+		// output contains “| Status=…” lines as well.
+		if strings.HasPrefix(line, "| ") && strings.HasSuffix(line, "|") {
+			inSection = strings.HasPrefix(line, "| Package versions")
+			continue
+		}
+		if !inSection || strings.HasPrefix(line, "+--") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 3 && fields[0] == "ii":
+			pkgs[strings.Split(fields[1], ":")[0]] = fields[2]
+		case len(fields) == 1:
+			parts := strings.Split(fields[0], "_")
+			if len(parts) == 3 {
+				pkgs[parts[0]] = parts[1]
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// buildEnvDiff compares the packages installed for the baseline build with
+// those installed for the build with the new packages. binaries are the
+// binaries from the .changes files.
+func buildEnvDiff(baselineLog, newLog string, binaries []string) ([]packageChange, error) {
+	before, err := installedPackages(baselineLog)
+	if err != nil {
+		return nil, err
+	}
+	after, err := installedPackages(newLog)
+	if err != nil {
+		return nil, err
+	}
+	ours := make(map[string]bool)
+	for _, bin := range binaries {
+		ours[bin] = true
+	}
+	var changes []packageChange
+	for pkg, oldVersion := range before {
+		if newVersion := after[pkg]; newVersion != oldVersion {
+			changes = append(changes, packageChange{pkg, oldVersion, newVersion, ours[pkg]})
+		}
+	}
+	for pkg, newVersion := range after {
+		if _, ok := before[pkg]; !ok {
+			changes = append(changes, packageChange{pkg, "", newVersion, ours[pkg]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Package < changes[j].Package
+	})
+	return changes, nil
+}
+
+// addBuildEnvDiffs compares the build environments of all packages which
+// failed with the new packages, but built fine in -recheck.
+func addBuildEnvDiffs(buildresults map[string]*buildResult, binaries []string) {
+	for src, result := range buildresults {
+		if result.err == nil || result.recheckErr != nil || result.logFile == "" || result.recheckLogFile == "" {
+			continue
+		}
+		changes, err := buildEnvDiff(result.recheckLogFile, result.logFile, binaries)
+		if err != nil {
+			log.Printf("Could not compare build environments of %s: %v\n", src, err)
+			continue
+		}
+		result.envDiff = changes
+	}
+}
+
+// printEnvDiff logs the build environment changes of r, pointing out
+// whether anything besides our packages changed.
+func (r *buildResult) printEnvDiff() {
+	if r.envDiff == nil {
+		return
+	}
+	var others []string
+	for _, c := range r.envDiff {
+		if !c.Ours {
+			others = append(others, c.String())
+		}
+	}
+	if len(others) == 0 {
+		log.Printf("    build environment: only our packages differ from the -recheck build\n")
+		return
+	}
+	log.Printf("    build environment: %d other packages differ from the -recheck build:\n", len(others))
+	for _, c := range others {
+		log.Printf("      %s\n", c)
+	}
+}
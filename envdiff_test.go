@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const packageVersionsLog = `+------------------------------------------------------------------------------+
+| Build environment                                                            |
++------------------------------------------------------------------------------+
+
+Kernel: Linux 6.1.0-18-amd64 #1 SMP PREEMPT_DYNAMIC Debian 6.1.76-1 (2024-02-01) amd64 (x86_64)
+Toolchain package versions: binutils_2.42-4 gcc-13_13.2.0-13 golang-1.22-go_1.22.1-1
+
++------------------------------------------------------------------------------+
+| Package versions                                                             |
++------------------------------------------------------------------------------+
+
+golang-foo-dev_2.0-1_all
+golang-go_2:1.22~2_amd64
+libc6_2.37-15_amd64
+
++------------------------------------------------------------------------------+
+| Build                                                                        |
++------------------------------------------------------------------------------+
+
+foo_1.0-1_amd64
+`
+
+const packageVersionsDpkgLog = `| Package versions                                                             |
++------------------------------------------------------------------------------+
+
+Desired=Unknown/Install/Remove/Purge/Hold
+| Status=Not/Inst/Conf-files/Unpacked/halF-conf/Half-inst/trig-aWait/Trig-pend
+||/ Name              Version      Architecture Description
++++-=================-============-============-=================================
+ii  golang-foo-dev    1.0-1        all          Foo library
+ii  golang-go         2:1.22~2     amd64        Go programming language compiler
+ii  libc6:amd64       2.37-14      amd64        GNU C Library: Shared libraries
+ii  oldtool           0.1-1        amd64        No longer needed
+
++------------------------------------------------------------------------------+
+| Build                                                                        |
++------------------------------------------------------------------------------+
+`
+
+func TestInstalledPackages(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		log  string
+		want map[string]string
+	}{
+		{
+			name: "name_version_arch",
+			log:  packageVersionsLog,
+			want: map[string]string{
+				"golang-foo-dev": "2.0-1",
+				"golang-go":      "2:1.22~2",
+				"libc6":          "2.37-15",
+			},
+		},
+		{
+			name: "dpkg -l",
+			log:  packageVersionsDpkgLog,
+			want: map[string]string{
+				"golang-foo-dev": "1.0-1",
+				"golang-go":      "2:1.22~2",
+				"libc6":          "2.37-14",
+				"oldtool":        "0.1-1",
+			},
+		},
+		{
+			name: "no section",
+			log:  "foo_1.0-1_amd64\n",
+			want: map[string]string{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := installedPackages(writeLog(t, tt.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("installedPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildEnvDiff(t *testing.T) {
+	got, err := buildEnvDiff(writeLog(t, packageVersionsDpkgLog), writeLog(t, packageVersionsLog), []string{"golang-foo-dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []packageChange{
+		{Package: "golang-foo-dev", OldVersion: "1.0-1", NewVersion: "2.0-1", Ours: true},
+		{Package: "libc6", OldVersion: "2.37-14", NewVersion: "2.37-15"},
+		{Package: "oldtool", OldVersion: "0.1-1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildEnvDiff() = %v, want %v", got, want)
+	}
+	if s := want[2].String(); s != "oldtool (removed 0.1-1)" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	// excerptContext is the number of lines shown around an error line.
+	excerptContext = 3
+	// excerptMaxLines bounds the length of an excerpt.
+	excerptMaxLines = 30
+)
+
+var (
+	// compileErrorRe matches compiler diagnostics such as
+	// “foo.go:12:3: undefined: bar” or “foo.c:3:1: error: …”.
+	compileErrorRe = regexp.MustCompile(`^\S+\.\w+:\d+(:\d+)?: (error: )?\S`)
+	// testEndRe matches the lines delimiting the output of a Go test.
+	testEndRe = regexp.MustCompile(`^(=== |--- (PASS|FAIL|SKIP)|FAIL|ok |PASS)`)
+)
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// around returns lines[i] with excerptContext lines of context.
+func around(lines []string, i int) []string {
+	start := max(i-excerptContext, 0)
+	end := min(i+excerptContext+1, len(lines))
+	return lines[start:end]
+}
+
+// testOutput returns the output of the failed Go test reported in lines[i]:
+// with go test -v, it precedes the “--- FAIL” line (after “=== RUN”),
+// otherwise it follows.
+func testOutput(lines []string, i int) []string {
+	start := i
+	for start > 0 && i-start < excerptMaxLines && !testEndRe.MatchString(lines[start-1]) {
+		start--
+	}
+	if start == 0 || !strings.HasPrefix(lines[start-1], "=== ") {
+		start = i
+	}
+	return append(append([]string{}, lines[start:i]...), block(lines, i, testEndRe.MatchString)...)
+}
+
+// block returns lines[i] and the following lines until one of them matches
+// stop (or is empty, if stop is nil), bounded by excerptMaxLines.
+func block(lines []string, i int, stop func(string) bool) []string {
+	end := i + 1
+	for end < len(lines) && end-i < excerptMaxLines {
+		if stop == nil && strings.TrimSpace(lines[end]) == "" || stop != nil && stop(lines[end]) {
+			break
+		}
+		end++
+	}
+	return lines[i:end]
+}
+
+func firstMatch(lines []string, match func(string) bool) int {
+	for i, line := range lines {
+		if match(line) {
+			return i
+		}
+	}
+	return -1
+}
+
+// extractExcerpt returns the most relevant lines of the failed build log at
+// path for the given failure category (see classifyBuildLog): the apt error
+// block, the output of the failing tests or the first compiler error.
+func extractExcerpt(path, category string) []string {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil
+	}
+
+	var excerpt []string
+	switch category {
+	case failureBuildDeps:
+		if i := firstMatch(lines, func(line string) bool {
+			return strings.Contains(line, "unmet dependencies") || strings.Contains(line, "Unable to satisfy dependencies")
+		}); i >= 0 {
+			excerpt = block(lines, i, nil)
+		} else if i := firstMatch(lines, func(line string) bool { return strings.HasPrefix(line, "E: ") }); i >= 0 {
+			excerpt = around(lines, i)
+		}
+
+	case failureTests:
+		for i, line := range lines {
+			if len(excerpt) >= excerptMaxLines {
+				break
+			}
+			if goFailRe.MatchString(line) && !strings.HasPrefix(line, " ") {
+				excerpt = append(excerpt, testOutput(lines, i)...)
+			}
+		}
+		if len(excerpt) == 0 {
+			if i := firstMatch(lines, func(line string) bool { return strings.HasPrefix(line, "dh_auto_test") }); i >= 0 {
+				excerpt = around(lines, i)
+			}
+		}
+
+	case failureCompile:
+		if i := firstMatch(lines, compileErrorRe.MatchString); i >= 0 {
+			excerpt = around(lines, i)
+		} else if i := firstMatch(lines, dhErrorRe.MatchString); i >= 0 {
+			excerpt = around(lines, i)
+		}
+
+	case failureDiskFull:
+		if i := firstMatch(lines, func(line string) bool { return strings.Contains(line, "No space left on device") }); i >= 0 {
+			excerpt = around(lines, i)
+		}
+	}
+	if len(excerpt) > excerptMaxLines {
+		excerpt = excerpt[:excerptMaxLines]
+	}
+	return excerpt
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractExcerpt(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		log      string
+		category string
+		want     []string
+	}{
+		{
+			name:     "build-deps",
+			log:      buildDepsLog,
+			category: failureBuildDeps,
+			want: []string{
+				"The following packages have unmet dependencies:",
+				" sbuild-build-depends-main-dummy : Depends: golang-foo-dev (>= 2.0) but 1.0-1 is to be installed",
+				"E: Unable to correct problems, you have held broken packages.",
+				"apt-get failed.",
+				"E: Package installation failed",
+			},
+		},
+		{
+			name:     "go test -v failures",
+			log:      goTestLog,
+			category: failureTests,
+			want: []string{
+				"    foo_test.go:12: got 1, want 2",
+				"--- FAIL: TestParse (0.00s)",
+				"    foo_test.go:30: unexpected error",
+				"--- FAIL: TestWrite (0.00s)",
+			},
+		},
+		{
+			name: "go test failures without -v",
+			log: "dh_auto_test -O--buildsystem=golang\n" +
+				"--- FAIL: TestParse (0.00s)\n" +
+				"    foo_test.go:12: got 1, want 2\n" +
+				"FAIL\n" +
+				"FAIL\texample.org/foo\t0.004s\n",
+			category: failureTests,
+			want: []string{
+				"--- FAIL: TestParse (0.00s)",
+				"    foo_test.go:12: got 1, want 2",
+			},
+		},
+		{
+			name:     "compile error",
+			log:      compileLog,
+			category: failureCompile,
+			want: []string{
+				"dh_auto_build -O--buildsystem=golang",
+				"\tcd obj-x86_64-linux-gnu && go install -trimpath -v -p 4 example.org/bar",
+				"example.org/bar",
+				"src/example.org/bar/bar.go:12:9: undefined: foo.Parse",
+				"src/example.org/bar/bar.go:20:2: too many arguments in call to foo.Write",
+				"dh_auto_build: error: cd obj-x86_64-linux-gnu && go install -trimpath -v -p 4 example.org/bar returned exit code 1",
+				"make: *** [debian/rules:4: binary] Error 25",
+			},
+		},
+		{
+			name:     "disk full",
+			log:      diskFullLog,
+			category: failureDiskFull,
+			want: []string{
+				"dh_auto_build",
+				"cp: error writing 'build/out.o': No space left on device",
+				"make: *** [Makefile:3: all] Error 1",
+				"",
+				"Fail-Stage: build",
+			},
+		},
+		{
+			name:     "unknown",
+			log:      goTestLog,
+			category: failureUnknown,
+			want:     nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractExcerpt(writeLog(t, tt.log), tt.category)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractExcerpt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractExcerptMaxLines(t *testing.T) {
+	var log strings.Builder
+	for i := range 100 {
+		fmt.Fprintf(&log, "=== RUN   Test%d\n    x_test.go:%d: failed\n--- FAIL: Test%d (0.00s)\n", i, i, i)
+	}
+	got := extractExcerpt(writeLog(t, log.String()), failureTests)
+	if len(got) != excerptMaxLines {
+		t.Errorf("len(extractExcerpt()) = %d, want %d", len(got), excerptMaxLines)
+	}
+}
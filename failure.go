@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Failure categories, as reported in the summary and the JSON output.
+const (
+	failureDiskFull  = "disk-full"
+	failureBuildDeps = "build-deps"
+	failureTests     = "test-failure"
+	failureCompile   = "compile-error"
+	failureSetup     = "setup"
+	failureUnknown   = "unknown"
+)
+
+var (
+	dhErrorRe = regexp.MustCompile(`^(dh_auto_\w+|dh_\w+)(: error:|: .* returned exit code)`)
+	goFailRe  = regexp.MustCompile(`^\s*--- FAIL: (\S+)`)
+)
+
+// buildLogSummary holds the lines of an sbuild log relevant for classifying
+// a failure.
+type buildLogSummary struct {
+	status     string
+	failStage  string
+	diskFull   bool
+	unmetDeps  bool
+	aptError   string
+	dhErrors   map[string]string // dh command → first error line
+	firstDh    string
+	failedTest []string
+}
+
+func scanBuildLog(path string) (*buildLogSummary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &buildLogSummary{dhErrors: make(map[string]string)}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// The summary at the end of the log overrides earlier matches, e.g.
+		// dpkg's “Status: install ok installed”.
+		if v, ok := strings.CutPrefix(line, "Status: "); ok {
+			s.status = strings.TrimSpace(v)
+		}
+		if v, ok := strings.CutPrefix(line, "Fail-Stage: "); ok {
+			s.failStage = strings.TrimSpace(v)
+		}
+		if strings.Contains(line, "No space left on device") {
+			s.diskFull = true
+		}
+		if strings.Contains(line, "unmet dependencies") || strings.Contains(line, "Unable to satisfy dependencies") {
+			s.unmetDeps = true
+		}
+		if strings.HasPrefix(line, "E: ") && s.aptError == "" {
+			s.aptError = strings.TrimSpace(line)
+		}
+		if m := dhErrorRe.FindStringSubmatch(line); m != nil {
+			if _, ok := s.dhErrors[m[1]]; !ok {
+				s.dhErrors[m[1]] = strings.TrimSpace(line)
+			}
+			if s.firstDh == "" {
+				s.firstDh = strings.TrimSpace(line)
+			}
+		}
+		if m := goFailRe.FindStringSubmatch(line); m != nil {
+			s.failedTest = append(s.failedTest, m[1])
+		}
+	}
+	return s, scanner.Err()
+}
+
+// classifyBuildLog parses the sbuild log of a failed build and returns a
+// failure category and a short reason.
+func classifyBuildLog(path string) (category, reason string) {
+	s, err := scanBuildLog(path)
+	if err != nil {
+		return failureUnknown, err.Error()
+	}
+	switch {
+	case s.diskFull:
+		return failureDiskFull, "No space left on device"
+
+	case s.failStage == "install-deps" || s.unmetDeps:
+		if s.aptError != "" {
+			return failureBuildDeps, s.aptError
+		}
+		return failureBuildDeps, "build dependencies could not be installed"
+
+	case s.dhErrors["dh_auto_test"] != "":
+		if len(s.failedTest) > 0 {
+			sort.Strings(s.failedTest)
+			return failureTests, "failing tests: " + strings.Join(uniq(s.failedTest), ", ")
+		}
+		return failureTests, s.dhErrors["dh_auto_test"]
+
+	case s.dhErrors["dh_auto_build"] != "":
+		return failureCompile, s.dhErrors["dh_auto_build"]
+
+	case s.dhErrors["dh_auto_configure"] != "":
+		return failureCompile, s.dhErrors["dh_auto_configure"]
+
+	case s.failStage == "build":
+		if s.firstDh != "" {
+			return failureCompile, s.firstDh
+		}
+		return failureCompile, "Fail-Stage: build"
+
+	case s.failStage != "":
+		return failureSetup, "Fail-Stage: " + s.failStage
+	}
+	if s.status != "" {
+		return failureUnknown, "Status: " + s.status
+	}
+	return failureUnknown, "no sbuild summary found in log"
+}
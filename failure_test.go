@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLog writes content to a build log file in a temporary directory and
+// returns its path.
+func writeLog(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "build.log")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const buildDepsLog = `Install foo build dependencies (apt-based resolver)
+-----------------------------------------------------
+
+The following packages have unmet dependencies:
+ sbuild-build-depends-main-dummy : Depends: golang-foo-dev (>= 2.0) but 1.0-1 is to be installed
+E: Unable to correct problems, you have held broken packages.
+apt-get failed.
+E: Package installation failed
+
++------------------------------------------------------------------------------+
+| Summary                                                                      |
++------------------------------------------------------------------------------+
+
+Fail-Stage: install-deps
+Status: failed
+`
+
+const goTestLog = `dh_auto_build -O--buildsystem=golang
+	cd obj-x86_64-linux-gnu && go install -trimpath -v -p 4 example.org/foo
+dh_auto_test -O--buildsystem=golang
+	cd obj-x86_64-linux-gnu && go test -vet=off -v -p 4 example.org/foo
+=== RUN   TestParse
+    foo_test.go:12: got 1, want 2
+--- FAIL: TestParse (0.00s)
+=== RUN   TestFormat
+--- PASS: TestFormat (0.00s)
+=== RUN   TestWrite
+    foo_test.go:30: unexpected error
+--- FAIL: TestWrite (0.00s)
+FAIL
+FAIL	example.org/foo	0.004s
+FAIL
+dh_auto_test: error: cd obj-x86_64-linux-gnu && go test -vet=off -v -p 4 example.org/foo returned exit code 1
+make: *** [debian/rules:4: binary] Error 25
+dpkg-buildpackage: error: debian/rules binary subprocess returned exit status 2
+
+Fail-Stage: build
+Status: attempted
+`
+
+const compileLog = `dh_auto_configure -O--buildsystem=golang
+dh_auto_build -O--buildsystem=golang
+	cd obj-x86_64-linux-gnu && go install -trimpath -v -p 4 example.org/bar
+example.org/bar
+src/example.org/bar/bar.go:12:9: undefined: foo.Parse
+src/example.org/bar/bar.go:20:2: too many arguments in call to foo.Write
+dh_auto_build: error: cd obj-x86_64-linux-gnu && go install -trimpath -v -p 4 example.org/bar returned exit code 1
+make: *** [debian/rules:4: binary] Error 25
+
+Fail-Stage: build
+Status: attempted
+`
+
+const diskFullLog = `dh_auto_build
+cp: error writing 'build/out.o': No space left on device
+make: *** [Makefile:3: all] Error 1
+
+Fail-Stage: build
+Status: attempted
+`
+
+func TestClassifyBuildLog(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		log      string
+		category string
+		reason   string
+	}{
+		{
+			name:     "build-deps",
+			log:      buildDepsLog,
+			category: failureBuildDeps,
+			reason:   "E: Unable to correct problems, you have held broken packages.",
+		},
+		{
+			name:     "go test failures",
+			log:      goTestLog,
+			category: failureTests,
+			reason:   "failing tests: TestParse, TestWrite",
+		},
+		{
+			name:     "compile error",
+			log:      compileLog,
+			category: failureCompile,
+			reason:   "dh_auto_build: error: cd obj-x86_64-linux-gnu && go install -trimpath -v -p 4 example.org/bar returned exit code 1",
+		},
+		{
+			name:     "disk full",
+			log:      diskFullLog,
+			category: failureDiskFull,
+			reason:   "No space left on device",
+		},
+		{
+			name: "test failure without go test output",
+			log: "dh_auto_test: error: make -j4 check returned exit code 2\n" +
+				"\nFail-Stage: build\nStatus: attempted\n",
+			category: failureTests,
+			reason:   "dh_auto_test: error: make -j4 check returned exit code 2",
+		},
+		{
+			name:     "build failure without dh",
+			log:      "make: *** [all] Error 2\n\nFail-Stage: build\nStatus: attempted\n",
+			category: failureCompile,
+			reason:   "Fail-Stage: build",
+		},
+		{
+			name:     "setup",
+			log:      "E: Error creating chroot session: skipping foo\n\nFail-Stage: create-session\nStatus: failed\n",
+			category: failureSetup,
+			reason:   "Fail-Stage: create-session",
+		},
+		{
+			name:     "dpkg status before summary",
+			log:      "Status: install ok installed\n\nStatus: skipped\n",
+			category: failureUnknown,
+			reason:   "Status: skipped",
+		},
+		{
+			name:     "empty",
+			log:      "",
+			category: failureUnknown,
+			reason:   "no sbuild summary found in log",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			category, reason := classifyBuildLog(writeLog(t, tt.log))
+			if category != tt.category || reason != tt.reason {
+				t.Errorf("classifyBuildLog() = %q, %q, want %q, %q", category, reason, tt.category, tt.reason)
+			}
+		})
+	}
+}
+
+func TestClassifyBuildLogMissing(t *testing.T) {
+	category, _ := classifyBuildLog(filepath.Join(t.TempDir(), "missing.log"))
+	if category != failureUnknown {
+		t.Errorf("classifyBuildLog(missing) = %q, want %q", category, failureUnknown)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"pault.ag/go/debian/deb"
+	"pault.ag/go/debian/version"
+)
+
+// goCodePrefix is where Debian Go library packages ship their sources.
+const goCodePrefix = "usr/share/gocode/src/"
+
+// goPackage describes a Go package shipped under goCodePrefix.
+type goPackage struct {
+	name string
+	// idents is the exported API: identifiers such as “Foo” or
+	// “Type.Method” mapped to their signature.
+	idents map[string]string
+	// files maps the file names (without tests) to their SHA-256 hash.
+	files map[string][sha256.Size]byte
+	// imports are the import paths imported by the package.
+	imports map[string]bool
+}
+
+// goAPI maps import paths to the packages shipped in a .deb.
+type goAPI map[string]*goPackage
+
+// goAPIChange is an exported identifier which was removed or changed.
+type goAPIChange struct {
+	ImportPath string `json:"import_path"`
+	Package    string `json:"package"`
+	Ident      string `json:"ident"`
+	Kind       string `json:"kind"` // “removed” or “changed”
+	Old        string `json:"old"`
+	New        string `json:"new,omitempty"`
+}
+
+func (c goAPIChange) String() string {
+	if c.Kind == "removed" {
+		return fmt.Sprintf("removed %s.%s (%s)", c.ImportPath, c.Ident, c.Old)
+	}
+	return fmt.Sprintf("changed %s.%s: %s -> %s", c.ImportPath, c.Ident, c.Old, c.New)
+}
+
+func nodeString(fset *token.FileSet, node any) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, node); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// withoutNames returns a copy of fields without parameter names, so that
+// renaming a parameter does not count as an API change.
+func withoutNames(fields *ast.FieldList) *ast.FieldList {
+	if fields == nil {
+		return nil
+	}
+	result := &ast.FieldList{}
+	for _, field := range fields.List {
+		for range max(len(field.Names), 1) {
+			result.List = append(result.List, &ast.Field{Type: field.Type})
+		}
+	}
+	return result
+}
+
+// funcSignature returns the signature of a function type without parameter
+// names, e.g. “func(int, string) error”.
+func funcSignature(fset *token.FileSet, ft *ast.FuncType) string {
+	return nodeString(fset, &ast.FuncType{
+		TypeParams: ft.TypeParams,
+		Params:     withoutNames(ft.Params),
+		Results:    withoutNames(ft.Results),
+	})
+}
+
+// receiverName returns the type name of a method receiver, e.g. “T” for
+// “(t *T[K])”.
+func receiverName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(e.X)
+	case *ast.IndexExpr:
+		return receiverName(e.X)
+	case *ast.IndexListExpr:
+		return receiverName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// addFile adds the exported identifiers of file to api.
+func (api *goPackage) addFile(fset *token.FileSet, file *ast.File) {
+	add := func(ident, sig string) {
+		// Files for different build constraints can declare the same
+		// identifier; keep a stable choice.
+		if cur, ok := api.idents[ident]; !ok || sig < cur {
+			api.idents[ident] = sig
+		}
+	}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() {
+				continue
+			}
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv := receiverName(d.Recv.List[0].Type)
+				if !ast.IsExported(recv) {
+					continue
+				}
+				name = recv + "." + name
+			}
+			add(name, funcSignature(fset, d.Type))
+
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if !s.Name.IsExported() {
+						continue
+					}
+					switch t := s.Type.(type) {
+					case *ast.StructType:
+						add(s.Name.Name, "struct")
+						for _, field := range t.Fields.List {
+							names := field.Names
+							if len(names) == 0 {
+								names = []*ast.Ident{{Name: receiverName(field.Type)}}
+							}
+							for _, n := range names {
+								if ast.IsExported(n.Name) {
+									add(s.Name.Name+"."+n.Name, nodeString(fset, field.Type))
+								}
+							}
+						}
+					case *ast.InterfaceType:
+						add(s.Name.Name, "interface")
+						for _, method := range t.Methods.List {
+							for _, n := range method.Names {
+								if ft, ok := method.Type.(*ast.FuncType); ok && n.IsExported() {
+									add(s.Name.Name+"."+n.Name, funcSignature(fset, ft))
+								}
+							}
+						}
+					default:
+						add(s.Name.Name, "type "+nodeString(fset, s.Type))
+					}
+
+				case *ast.ValueSpec:
+					kind := strings.ToLower(d.Tok.String())
+					if s.Type != nil {
+						kind += " " + nodeString(fset, s.Type)
+					}
+					for _, n := range s.Names {
+						if n.IsExported() {
+							add(n.Name, kind)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// isImportable reports whether other packages can import importPath.
+func isImportable(importPath string) bool {
+	for _, elem := range strings.Split(importPath, "/") {
+		if elem == "internal" || elem == "vendor" || elem == "testdata" {
+			return false
+		}
+	}
+	return true
+}
+
+// readGoAPI returns the Go packages shipped in the .deb at debPath.
+func readGoAPI(debPath string) (goAPI, error) {
+	d, closer, err := deb.LoadFile(debPath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	api := make(goAPI)
+	fset := token.NewFileSet()
+	for {
+		hdr, err := d.Data.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if hdr.Typeflag != tar.TypeReg || !strings.HasPrefix(name, goCodePrefix) || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		importPath := path.Dir(strings.TrimPrefix(name, goCodePrefix))
+		if !isImportable(importPath) {
+			continue
+		}
+		src, err := io.ReadAll(d.Data)
+		if err != nil {
+			return nil, err
+		}
+		pkg, ok := api[importPath]
+		if !ok {
+			pkg = &goPackage{
+				idents:  make(map[string]string),
+				files:   make(map[string][sha256.Size]byte),
+				imports: make(map[string]bool),
+			}
+			api[importPath] = pkg
+		}
+		pkg.files[path.Base(name)] = sha256.Sum256(src)
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, src, parser.SkipObjectResolution)
+		if err != nil || file.Name.Name == "main" {
+			continue
+		}
+		if pkg.name == "" {
+			pkg.name = file.Name.Name
+		}
+		for _, imp := range file.Imports {
+			pkg.imports[strings.Trim(imp.Path.Value, "`\"")] = true
+		}
+		pkg.addFile(fset, file)
+	}
+	// Directories with only non-Go files, or only commands, are no
+	// importable packages.
+	for importPath, pkg := range api {
+		if pkg.name == "" {
+			delete(api, importPath)
+		}
+	}
+	return api, nil
+}
+
+// diffGoAPI returns the identifiers of old which were removed or changed in
+// new.
+func diffGoAPI(old, new goAPI) []goAPIChange {
+	var changes []goAPIChange
+	for importPath, oldPkg := range old {
+		newPkg := new[importPath]
+		for ident, sig := range oldPkg.idents {
+			change := goAPIChange{ImportPath: importPath, Package: oldPkg.name, Ident: ident, Old: sig}
+			if newPkg == nil {
+				change.Kind = "removed"
+			} else if newSig, ok := newPkg.idents[ident]; !ok {
+				change.Kind = "removed"
+			} else if newSig != sig {
+				change.Kind = "changed"
+				change.New = newSig
+			} else {
+				continue
+			}
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].ImportPath != changes[j].ImportPath {
+			return changes[i].ImportPath < changes[j].ImportPath
+		}
+		return changes[i].Ident < changes[j].Ident
+	})
+	return changes
+}
+
+// archiveDownloadAllowed reports whether archive .debs may be downloaded
+// from the mirror: not with -dry_run, and with -offline only from a local
+// (file://) mirror.
+func archiveDownloadAllowed() bool {
+	if *dryRun {
+		return false
+	}
+	return !*offline || strings.HasPrefix(normalizeMirror(*mirror), "file://")
+}
+
+// downloadArchiveDeb fetches filename (relative to the mirror) into a
+// temporary file, whose path is returned.
+func downloadArchiveDeb(filename string) (string, error) {
+	if *offline && !strings.HasPrefix(normalizeMirror(*mirror), "file://") {
+		return "", fmt.Errorf("not downloading from %s in -offline mode", *mirror)
+	}
+	r, err := openMirrorFile(*mirror, filename)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	f, err := os.CreateTemp("", "ratt-*-"+path.Base(filename))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// goDeb is a new .deb shipping Go sources, together with the archive
+// version of the same binary package.
+type goDeb struct {
+	bin    binPkg
+	newAPI goAPI
+	oldAPI goAPI
+}
+
+// archiveGoDebs reads the Go sources in the new .debs and in the archive
+// version of the same binary packages (from packagesPaths), which are
+// downloaded from the mirror (see archiveDownloadAllowed). Binary packages
+// which are not in the archive yet are returned without oldAPI. complete is
+// false if any of the new .debs with Go sources could not be compared.
+func archiveGoDebs(newBins []binPkg, packagesPaths []string) (result []goDeb, complete bool) {
+	complete = true
+	var pkgs []binPkg
+	for _, bin := range newBins {
+		newAPI, err := readGoAPI(bin.Filename)
+		if err != nil {
+			log.Printf("Could not read Go sources from %s: %v\n", bin.Filename, err)
+			complete = false
+			continue
+		}
+		if len(newAPI) == 0 {
+			continue
+		}
+		if !archiveDownloadAllowed() {
+			log.Printf("Not downloading the archive version of %s with -dry_run or -offline, cannot compare its Go packages\n", bin.Package)
+			complete = false
+			continue
+		}
+
+		if pkgs == nil {
+			pkgs, err = loadBinaryIndices(packagesPaths, buildArch())
+			if err != nil {
+				log.Printf("Could not load binary indices: %v\n", err)
+				return nil, false
+			}
+		}
+		var old *binPkg
+		for i, pkg := range pkgs {
+			if pkg.Package == bin.Package && (old == nil || version.Compare(pkg.Version, old.Version) > 0) {
+				old = &pkgs[i]
+			}
+		}
+		if old == nil {
+			result = append(result, goDeb{bin: bin, newAPI: newAPI})
+			continue
+		}
+		oldDeb, err := downloadArchiveDeb(old.Filename)
+		if err != nil {
+			log.Printf("Could not download %s_%s from the archive: %v\n", old.Package, old.Version, err)
+			complete = false
+			continue
+		}
+		oldAPI, err := readGoAPI(oldDeb)
+		os.Remove(oldDeb)
+		if err != nil {
+			log.Printf("Could not read Go sources from %s_%s: %v\n", old.Package, old.Version, err)
+			complete = false
+			continue
+		}
+		result = append(result, goDeb{bin: bin, newAPI: newAPI, oldAPI: oldAPI})
+	}
+	return result, complete
+}
+
+// goAPIChanges returns the exported identifiers removed or changed by the new
+// .debs.
+func goAPIChanges(debs []goDeb) []goAPIChange {
+	var changes []goAPIChange
+	for _, d := range debs {
+		changes = append(changes, diffGoAPI(d.oldAPI, d.newAPI)...)
+	}
+	return changes
+}
+
+// apiBreakPattern returns a regular expression matching compiler errors
+// which refer to the identifier of c, e.g. “undefined: foo.Bar” or
+// “x.Baz undefined (type *foo.T has no field or method Baz)”.
+func apiBreakPattern(c goAPIChange) *regexp.Regexp {
+	typ, member, ok := strings.Cut(c.Ident, ".")
+	if !ok {
+		return regexp.MustCompile(`\b` + regexp.QuoteMeta(c.Package+"."+c.Ident) + `\b`)
+	}
+	return regexp.MustCompile(`\b` + regexp.QuoteMeta(c.Package+"."+typ) + `\b.*\b` + regexp.QuoteMeta(member) + `\b|\b` +
+		regexp.QuoteMeta(member) + `\b.*\b` + regexp.QuoteMeta(c.Package+"."+typ) + `\b`)
+}
+
+// attributeAPIBreaks matches the compiler errors in logFile against changes
+// and explains the failure, e.g. “fails because example.com/foo.Bar was
+// removed”.
+func attributeAPIBreaks(logFile string, changes []goAPIChange) []string {
+	lines, err := readLines(logFile)
+	if err != nil {
+		return nil
+	}
+	patterns := make([]*regexp.Regexp, len(changes))
+	for i, c := range changes {
+		patterns[i] = apiBreakPattern(c)
+	}
+	seen := make(map[int]bool)
+	var reasons []string
+	for _, line := range lines {
+		if !compileErrorRe.MatchString(line) {
+			continue
+		}
+		for i, pattern := range patterns {
+			if !seen[i] && pattern.MatchString(line) {
+				seen[i] = true
+				reasons = append(reasons, fmt.Sprintf("fails because %s.%s was %s", changes[i].ImportPath, changes[i].Ident, changes[i].Kind))
+			}
+		}
+	}
+	return reasons
+}
+
+// addAPIBreaks attributes the failed builds to the Go API changes.
+func addAPIBreaks(buildresults map[string]*buildResult, changes []goAPIChange) {
+	if len(changes) == 0 {
+		return
+	}
+	for _, result := range buildresults {
+		if result.err != nil && result.logFile != "" {
+			result.apiBreaks = attributeAPIBreaks(result.logFile, changes)
+		}
+	}
+}
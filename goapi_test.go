@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+// parseGoAPI returns the goAPI of a single package example.org/foo with the
+// given source.
+func parseGoAPI(t *testing.T, src string) goAPI {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "foo.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &goPackage{
+		name:    file.Name.Name,
+		idents:  make(map[string]string),
+		files:   make(map[string][sha256.Size]byte),
+		imports: make(map[string]bool),
+	}
+	pkg.addFile(fset, file)
+	return goAPI{"example.org/foo": pkg}
+}
+
+func TestDiffGoAPI(t *testing.T) {
+	old := parseGoAPI(t, `package foo
+
+type T struct {
+	Name string
+	Size int
+	internal bool
+}
+
+func (t *T) Close() error { return nil }
+func (t *T) helper()      {}
+
+type Reader interface {
+	Read(p []byte) (n int, err error)
+}
+
+const Version = "1"
+
+func Parse(s string, strict bool) (*T, error) { return nil, nil }
+func Format(t *T) string                      { return "" }
+func unexported()                             {}
+`)
+	new := parseGoAPI(t, `package foo
+
+type T struct {
+	Name string
+	Size int64
+}
+
+func (t *T) Close() error { return nil }
+
+type Reader interface {
+	Read(buf []byte) (int, error)
+}
+
+const Version = "2"
+
+func Parse(input string, strict bool) (*T, error) { return nil, nil }
+func Write(t *T) error                            { return nil }
+`)
+	want := []goAPIChange{
+		{ImportPath: "example.org/foo", Package: "foo", Ident: "Format", Kind: "removed", Old: "func(*T) string"},
+		{ImportPath: "example.org/foo", Package: "foo", Ident: "T.Size", Kind: "changed", Old: "int", New: "int64"},
+	}
+	if got := diffGoAPI(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffGoAPI() = %v, want %v", got, want)
+	}
+}
+
+func TestDiffGoAPIRemovedPackage(t *testing.T) {
+	old := parseGoAPI(t, "package foo\n\nfunc Parse() {}\n")
+	want := []goAPIChange{
+		{ImportPath: "example.org/foo", Package: "foo", Ident: "Parse", Kind: "removed", Old: "func()"},
+	}
+	if got := diffGoAPI(old, goAPI{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffGoAPI() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/version"
+)
+
+// affectedImportPaths returns the import paths of the new .debs, and those
+// which are affected by the new version: packages whose files changed, which
+// were added or removed, or which import an affected package.
+func affectedImportPaths(debs []goDeb) (ours, affected map[string]bool) {
+	ours = make(map[string]bool)
+	affected = make(map[string]bool)
+	for _, d := range debs {
+		for importPath, pkg := range d.newAPI {
+			ours[importPath] = true
+			if old, ok := d.oldAPI[importPath]; !ok || !maps.Equal(old.files, pkg.files) {
+				affected[importPath] = true
+			}
+		}
+		for importPath := range d.oldAPI {
+			ours[importPath] = true
+			if _, ok := d.newAPI[importPath]; !ok {
+				affected[importPath] = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, d := range debs {
+			for importPath, pkg := range d.newAPI {
+				if affected[importPath] {
+					continue
+				}
+				for imp := range pkg.imports {
+					if affected[imp] {
+						affected[importPath] = true
+						changed = true
+						break
+					}
+				}
+			}
+		}
+	}
+	return ours, affected
+}
+
+// goImportPaths returns the XS-Go-Import-Path of src, which can list
+// multiple import paths.
+func goImportPaths(src control.SourceIndex) []string {
+	value := src.Values["Go-Import-Path"]
+	if value == "" {
+		value = src.Values["XS-Go-Import-Path"]
+	}
+	var paths []string
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// scanImports returns the import paths imported by the Go files in dir,
+// including tests, but excluding vendored code.
+func scanImports(dir string) (map[string]bool, error) {
+	imports := make(map[string]bool)
+	fset := token.NewFileSet()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case "vendor", "testdata", ".git", ".pc", "_build":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			return nil
+		}
+		for _, imp := range file.Imports {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil {
+				imports[p] = true
+			}
+		}
+		return nil
+	})
+	return imports, err
+}
+
+// goRdepsOf returns the binary packages in pkgs which depend, directly or
+// through other packages, on any of binaries: Go libraries through which a
+// reverse-build-dependency can use the new packages without importing them
+// itself.
+func goRdepsOf(pkgs []binPkg, binaries []string) map[string]bool {
+	reached := make(map[string]bool)
+	for _, bin := range binaries {
+		reached[bin] = true
+	}
+	rdeps := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, pkg := range pkgs {
+			if reached[pkg.Package] {
+				continue
+			}
+			for _, p := range append(pkg.PreDepends.GetAllPossibilities(), pkg.Depends.GetAllPossibilities()...) {
+				if reached[p.Name] {
+					reached[pkg.Package] = true
+					rdeps[pkg.Package] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return rdeps
+}
+
+// goImportDecision checks whether src, which imports imports, uses any of the
+// affected packages. If not, it returns why the build is not needed. indirect
+// is set if src build-depends on one of indirectBins (see goRdepsOf), so
+// that it may still use an affected package through another library.
+func goImportDecision(src control.SourceIndex, imports, ours, affected, indirectBins map[string]bool) (reason string, indirect bool) {
+	var unchanged []string
+	for imp := range imports {
+		if affected[imp] {
+			return "", false
+		}
+		if ours[imp] {
+			unchanged = append(unchanged, imp)
+		}
+	}
+	if len(unchanged) == 0 {
+		reason = "imports none of the packages from the new .debs"
+	} else {
+		sort.Strings(unchanged)
+		reason = "only imports unchanged packages: " + strings.Join(unchanged, ", ")
+	}
+	var via []string
+	bd := buildDependsOf(src)
+	for _, p := range bd.GetAllPossibilities() {
+		if indirectBins[p.Name] {
+			via = append(via, p.Name)
+		}
+	}
+	if len(via) > 0 {
+		sort.Strings(via)
+		return reason + ", but may use them through " + strings.Join(uniq(via), ", "), true
+	}
+	return reason, false
+}
+
+// goImportReason fetches the source of src and checks its imports (see
+// goImportDecision).
+func goImportReason(src control.SourceIndex, ours, affected, indirectBins map[string]bool) (reason string, indirect bool) {
+	dir, err := os.MkdirTemp("", "ratt-imports-"+src.Package+"-")
+	if err != nil {
+		log.Printf("Could not check imports of %s: %v\n", src.Package, err)
+		return "", false
+	}
+	defer os.RemoveAll(dir)
+	tree, err := fetchSource(src.Package, src.Version, dir)
+	if err != nil {
+		log.Printf("Could not check imports of %s: %v\n", src.Package, err)
+		return "", false
+	}
+	imports, err := scanImports(tree)
+	if err != nil {
+		log.Printf("Could not check imports of %s: %v\n", src.Package, err)
+		return "", false
+	}
+	return goImportDecision(src, imports, ours, affected, indirectBins)
+}
+
+// filterByGoImports checks which reverse-build-dependencies import a Go
+// package affected by the new .debs. Only Go sources (with XS-Go-Import-Path)
+// which directly build-depend on one of binaries are considered. It returns
+// the sources which do not, with the reason: unaffected ones can be skipped,
+// while indirect ones may use an affected package through another Go library
+// from pkgs and can only be deferred. If not all new .debs with Go sources
+// could be compared with the archive (complete is false), nothing is
+// filtered, as the import paths of the others are unknown.
+func filterByGoImports(sources []control.SourceIndex, pkgs []binPkg, binaries []string, rebuild map[string][]version.Version, debs []goDeb, complete bool) (unaffected, indirect map[string]string) {
+	if !complete {
+		log.Printf("-go-import-filter: not all new .debs with Go sources could be compared with the archive, building all packages\n")
+		return nil, nil
+	}
+	ours, affected := affectedImportPaths(debs)
+	if len(ours) == 0 {
+		log.Printf("-go-import-filter: the new .debs contain no Go packages\n")
+		return nil, nil
+	}
+	changed := make([]string, 0, len(affected))
+	for importPath := range affected {
+		changed = append(changed, importPath)
+	}
+	sort.Strings(changed)
+	log.Printf("Go packages affected by the new version: %s\n", strings.Join(changed, " "))
+
+	ourBins := make(map[string]bool)
+	for _, bin := range binaries {
+		ourBins[bin] = true
+	}
+	indirectBins := goRdepsOf(pkgs, binaries)
+	newest := make(map[string]control.SourceIndex)
+	for _, src := range sources {
+		if _, ok := rebuild[src.Package]; !ok {
+			continue
+		}
+		if cur, ok := newest[src.Package]; !ok || version.Compare(src.Version, cur.Version) > 0 {
+			newest[src.Package] = src
+		}
+	}
+
+	unaffected = make(map[string]string)
+	indirect = make(map[string]string)
+	for name, src := range newest {
+		if len(goImportPaths(src)) == 0 {
+			continue
+		}
+		direct := false
+		bd := buildDependsOf(src)
+		for _, p := range bd.GetAllPossibilities() {
+			direct = direct || ourBins[p.Name]
+		}
+		if !direct {
+			continue
+		}
+		reason, viaOther := goImportReason(src, ours, affected, indirectBins)
+		switch {
+		case reason == "":
+		case viaOther:
+			indirect[name] = reason
+		default:
+			unaffected[name] = reason
+		}
+	}
+	return unaffected, indirect
+}
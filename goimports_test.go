@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
+)
+
+func TestGoRdepsOf(t *testing.T) {
+	dep := func(s string) dependency.Dependency {
+		d, err := dependency.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *d
+	}
+	pkgs := []binPkg{
+		{Package: "golang-foo-dev", Depends: dep("golang-x-text-dev")},
+		{Package: "golang-bar-dev", Depends: dep("golang-foo-dev (>= 1.0)")},
+		{Package: "golang-baz-dev", Depends: dep("golang-bar-dev | golang-qux-dev")},
+		{Package: "golang-qux-dev", Depends: dep("golang-x-text-dev")},
+	}
+	got := goRdepsOf(pkgs, []string{"golang-foo-dev"})
+	want := map[string]bool{"golang-bar-dev": true, "golang-baz-dev": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("goRdepsOf() = %v, want %v", got, want)
+	}
+}
+
+func TestGoImportDecision(t *testing.T) {
+	ours := map[string]bool{
+		"example.org/foo":       true,
+		"example.org/foo/util":  true,
+		"example.org/foo/parse": true,
+	}
+	affected := map[string]bool{"example.org/foo/parse": true}
+	// golang-bar-dev depends on golang-foo-dev and may import
+	// example.org/foo/parse.
+	indirectBins := map[string]bool{"golang-bar-dev": true}
+	source := func(buildDepends string) control.SourceIndex {
+		return control.SourceIndex{
+			Paragraph: control.Paragraph{Values: map[string]string{"Build-Depends": buildDepends}},
+			Package:   "rdep",
+		}
+	}
+	for _, tt := range []struct {
+		name         string
+		buildDepends string
+		imports      []string
+		reason       string
+		indirect     bool
+	}{
+		{
+			name:         "imports an affected package",
+			buildDepends: "golang-foo-dev",
+			imports:      []string{"fmt", "example.org/foo/parse"},
+		},
+		{
+			name:         "imports only unchanged packages",
+			buildDepends: "golang-foo-dev",
+			imports:      []string{"fmt", "example.org/foo/util", "example.org/foo"},
+			reason:       "only imports unchanged packages: example.org/foo, example.org/foo/util",
+		},
+		{
+			name:         "imports none",
+			buildDepends: "debhelper-compat (= 13), golang-foo-dev",
+			imports:      []string{"fmt"},
+			reason:       "imports none of the packages from the new .debs",
+		},
+		{
+			name:         "indirect through another library",
+			buildDepends: "golang-foo-dev, golang-bar-dev",
+			imports:      []string{"example.org/bar"},
+			reason:       "imports none of the packages from the new .debs, but may use them through golang-bar-dev",
+			indirect:     true,
+		},
+		{
+			name:         "affected wins over indirect",
+			buildDepends: "golang-foo-dev, golang-bar-dev",
+			imports:      []string{"example.org/bar", "example.org/foo/parse"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			imports := make(map[string]bool)
+			for _, imp := range tt.imports {
+				imports[imp] = true
+			}
+			reason, indirect := goImportDecision(source(tt.buildDepends), imports, ours, affected, indirectBins)
+			if reason != tt.reason || indirect != tt.indirect {
+				t.Errorf("goImportDecision() = %q, %v, want %q, %v", reason, indirect, tt.reason, tt.indirect)
+			}
+		})
+	}
+}
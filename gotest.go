@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	goTestResultRe  = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+)`)
+	goTestPackageRe = regexp.MustCompile(`^(ok|FAIL)\s+(\S+)\s`)
+)
+
+// goTestEvent is a line of go test -json output (see go doc test2json).
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+}
+
+// goTestResults parses the go test output in logFile, in either the plain
+// text or the -json format, and returns whether each test passed. Tests are
+// named “importpath.TestName” if the package is known.
+func goTestResults(logFile string) (map[string]bool, error) {
+	lines, err := readLines(logFile)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]bool)
+	// In the text format, the package is only printed after its tests.
+	var pending []string
+	pendingPassed := make(map[string]bool)
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "{") {
+			var ev goTestEvent
+			if json.Unmarshal([]byte(trimmed), &ev) != nil || ev.Test == "" {
+				continue
+			}
+			switch ev.Action {
+			case "pass", "fail":
+				results[ev.Package+"."+ev.Test] = ev.Action == "pass"
+			}
+			continue
+		}
+		if m := goTestResultRe.FindStringSubmatch(line); m != nil {
+			if m[1] == "SKIP" {
+				continue
+			}
+			pending = append(pending, m[2])
+			pendingPassed[m[2]] = m[1] == "PASS"
+			continue
+		}
+		if m := goTestPackageRe.FindStringSubmatch(line); m != nil {
+			for _, test := range pending {
+				results[m[2]+"."+test] = pendingPassed[test]
+			}
+			pending = nil
+			pendingPassed = make(map[string]bool)
+		}
+	}
+	for _, test := range pending {
+		results[test] = pendingPassed[test]
+	}
+	return results, nil
+}
+
+// goTestDiff compares the go test results of the build with the new packages
+// to those of the baseline (-recheck) build.
+type goTestDiff struct {
+	NewlyFailing   []string `json:"newly_failing,omitempty"`
+	AlreadyFailing []string `json:"already_failing,omitempty"`
+	Fixed          []string `json:"fixed,omitempty"`
+}
+
+func compareGoTests(baselineLog, newLog string) (*goTestDiff, error) {
+	before, err := goTestResults(baselineLog)
+	if err != nil {
+		return nil, err
+	}
+	after, err := goTestResults(newLog)
+	if err != nil {
+		return nil, err
+	}
+	if len(before) == 0 && len(after) == 0 {
+		return nil, nil
+	}
+	diff := &goTestDiff{}
+	for test, passed := range after {
+		if passed {
+			continue
+		}
+		if passedBefore, ok := before[test]; ok && !passedBefore {
+			diff.AlreadyFailing = append(diff.AlreadyFailing, test)
+		} else {
+			diff.NewlyFailing = append(diff.NewlyFailing, test)
+		}
+	}
+	for test, passed := range before {
+		if !passed && after[test] {
+			diff.Fixed = append(diff.Fixed, test)
+		}
+	}
+	sort.Strings(diff.NewlyFailing)
+	sort.Strings(diff.AlreadyFailing)
+	sort.Strings(diff.Fixed)
+	return diff, nil
+}
+
+// addGoTestDiffs compares the go test results of all packages which were
+// rebuilt in -recheck.
+func addGoTestDiffs(buildresults map[string]*buildResult) {
+	for src, result := range buildresults {
+		if result.logFile == "" || result.recheckLogFile == "" {
+			continue
+		}
+		diff, err := compareGoTests(result.recheckLogFile, result.logFile)
+		if err != nil {
+			log.Printf("Could not compare go test results of %s: %v\n", src, err)
+			continue
+		}
+		result.testDiff = diff
+	}
+}
+
+// printTestDiff logs the go test result changes of r.
+func (r *buildResult) printTestDiff() {
+	d := r.testDiff
+	if d == nil {
+		return
+	}
+	if len(d.NewlyFailing) > 0 {
+		log.Printf("    tests newly failing: %s\n", strings.Join(d.NewlyFailing, ", "))
+	}
+	if len(d.AlreadyFailing) > 0 {
+		log.Printf("    tests already failing: %s\n", strings.Join(d.AlreadyFailing, ", "))
+	}
+	if len(d.Fixed) > 0 {
+		log.Printf("    tests fixed: %s\n", strings.Join(d.Fixed, ", "))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGoTestResults(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		log  string
+		want map[string]bool
+	}{
+		{
+			name: "text",
+			log:  goTestLog,
+			want: map[string]bool{
+				"example.org/foo.TestParse":  false,
+				"example.org/foo.TestFormat": true,
+				"example.org/foo.TestWrite":  false,
+			},
+		},
+		{
+			name: "text with subtests and several packages",
+			log: "=== RUN   TestA\n" +
+				"=== RUN   TestA/sub\n" +
+				"    --- FAIL: TestA/sub (0.00s)\n" +
+				"--- FAIL: TestA (0.00s)\n" +
+				"--- SKIP: TestSkipped (0.00s)\n" +
+				"FAIL\n" +
+				"FAIL\texample.org/a\t0.010s\n" +
+				"--- PASS: TestB (0.00s)\n" +
+				"ok  \texample.org/b\t(cached)\n",
+			want: map[string]bool{
+				"example.org/a.TestA":     false,
+				"example.org/a.TestA/sub": false,
+				"example.org/b.TestB":     true,
+			},
+		},
+		{
+			name: "text without package line",
+			log:  "--- FAIL: TestA (0.00s)\n",
+			want: map[string]bool{"TestA": false},
+		},
+		{
+			name: "json",
+			log: `	cd obj-x86_64-linux-gnu && go test -json example.org/foo
+{"Action":"run","Package":"example.org/foo","Test":"TestParse"}
+{"Action":"output","Package":"example.org/foo","Test":"TestParse","Output":"--- FAIL: TestParse (0.00s)\n"}
+{"Action":"fail","Package":"example.org/foo","Test":"TestParse","Elapsed":0}
+{"Action":"pass","Package":"example.org/foo","Test":"TestFormat","Elapsed":0}
+{"Action":"skip","Package":"example.org/foo","Test":"TestSkipped","Elapsed":0}
+{"Action":"fail","Package":"example.org/foo","Elapsed":0.004}
+`,
+			want: map[string]bool{
+				"example.org/foo.TestParse":  false,
+				"example.org/foo.TestFormat": true,
+			},
+		},
+		{
+			name: "no tests",
+			log:  compileLog,
+			want: map[string]bool{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := goTestResults(writeLog(t, tt.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("goTestResults() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareGoTests(t *testing.T) {
+	baseline := "--- FAIL: TestFlaky (0.00s)\n" +
+		"--- PASS: TestParse (0.00s)\n" +
+		"--- FAIL: TestFormat (0.00s)\n" +
+		"FAIL\texample.org/foo\t0.004s\n"
+	current := "--- FAIL: TestFlaky (0.00s)\n" +
+		"--- FAIL: TestParse (0.00s)\n" +
+		"--- PASS: TestFormat (0.00s)\n" +
+		"--- FAIL: TestNew (0.00s)\n" +
+		"FAIL\texample.org/foo\t0.004s\n"
+	got, err := compareGoTests(writeLog(t, baseline), writeLog(t, current))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &goTestDiff{
+		NewlyFailing:   []string{"example.org/foo.TestNew", "example.org/foo.TestParse"},
+		AlreadyFailing: []string{"example.org/foo.TestFlaky"},
+		Fixed:          []string{"example.org/foo.TestFormat"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compareGoTests() = %+v, want %+v", got, want)
+	}
+
+	got, err = compareGoTests(writeLog(t, compileLog), writeLog(t, compileLog))
+	if err != nil || got != nil {
+		t.Errorf("compareGoTests() without tests = %+v, %v, want nil, nil", got, err)
+	}
+}
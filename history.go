@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"pault.ag/go/debian/version"
+)
+
+const (
+	// buildHistoryFile is the name of the build time database in -state-dir.
+	buildHistoryFile = "build-times.json"
+	// maxBuildRecords is the number of builds kept per source package.
+	maxBuildRecords = 20
+)
+
+// buildRecord is a past build of a source package.
+type buildRecord struct {
+	Version     string  `json:"version"`
+	WallSeconds float64 `json:"wall_seconds"`
+	CPUSeconds  float64 `json:"cpu_seconds"`
+	// MaxRSSKiB is the RSS of the largest process (see resourceUsage).
+	MaxRSSKiB int64     `json:"max_rss_kib"`
+	Success   bool      `json:"success"`
+	Time      time.Time `json:"time"`
+}
+
+// buildHistory is the build time database, mapping source package names to
+// their past builds, oldest first.
+type buildHistory struct {
+	path    string
+	Sources map[string][]buildRecord `json:"sources"`
+}
+
+// defaultStateDir returns $XDG_STATE_HOME/ratt, falling back to
+// ~/.local/state/ratt.
+func defaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "ratt")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "state", "ratt")
+}
+
+// loadBuildHistory reads the build time database from stateDir. A missing
+// database is not an error.
+func loadBuildHistory(stateDir string) (*buildHistory, error) {
+	h := &buildHistory{
+		path:    filepath.Join(stateDir, buildHistoryFile),
+		Sources: make(map[string][]buildRecord),
+	}
+	b, err := os.ReadFile(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(b, h); err != nil {
+		return h, err
+	}
+	if h.Sources == nil {
+		h.Sources = make(map[string][]buildRecord)
+	}
+	return h, nil
+}
+
+// save writes the database, replacing the previous file atomically.
+func (h *buildHistory) save() error {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// record adds the builds of the current run.
+func (h *buildHistory) record(buildresults map[string]*buildResult) {
+	now := time.Now()
+	for src, result := range buildresults {
+		if result.usage.wall == 0 || result.version == nil {
+			continue
+		}
+		records := append(h.Sources[src], buildRecord{
+			Version:     result.version.String(),
+			WallSeconds: result.usage.wall.Seconds(),
+			CPUSeconds:  result.usage.cpu.Seconds(),
+			MaxRSSKiB:   result.usage.maxRSS,
+			Success:     result.err == nil,
+			Time:        now,
+		})
+		if len(records) > maxBuildRecords {
+			records = records[len(records)-maxBuildRecords:]
+		}
+		h.Sources[src] = records
+	}
+}
+
+// estimate returns the expected duration of building src at ver: the most
+// recent successful build of the same version, or else of any version. Failed
+// builds are only used if there is no successful one.
+func (h *buildHistory) estimate(src, ver string) (time.Duration, bool) {
+	records := h.Sources[src]
+	var best *buildRecord
+	score := func(r *buildRecord) int {
+		s := 0
+		if r.Success {
+			s += 2
+		}
+		if r.Version == ver {
+			s++
+		}
+		return s
+	}
+	for i := range records {
+		// Later records win ties, as they are more recent.
+		if best == nil || score(&records[i]) >= score(best) {
+			best = &records[i]
+		}
+	}
+	if best == nil {
+		return 0, false
+	}
+	return time.Duration(best.WallSeconds * float64(time.Second)), true
+}
+
+// previous returns the usage of the most recent successful build of src at
+// ver.
+func (h *buildHistory) previous(src, ver string) (*resourceUsage, bool) {
+	records := h.Sources[src]
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Success && r.Version == ver {
+			return &resourceUsage{
+				wall:   time.Duration(r.WallSeconds * float64(time.Second)),
+				cpu:    time.Duration(r.CPUSeconds * float64(time.Second)),
+				maxRSS: r.MaxRSSKiB,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// addPreviousUsage sets the usage of the previous build of each result, to
+// detect slowdowns without a baseline build. It must be called before the
+// current builds are recorded.
+func (h *buildHistory) addPreviousUsage(buildresults map[string]*buildResult) {
+	for src, result := range buildresults {
+		if result.version == nil {
+			continue
+		}
+		if usage, ok := h.previous(src, result.version.String()); ok {
+			result.previousUsage = usage
+		}
+	}
+}
+
+// buildEstimates returns the expected build duration of each source in
+// rebuild. Sources without history are assumed to take the median duration
+// of the others. The number of such sources is returned as well.
+func (h *buildHistory) buildEstimates(rebuild map[string][]version.Version) (map[string]time.Duration, int) {
+	estimates := make(map[string]time.Duration)
+	var known []time.Duration
+	var unknown []string
+	for src, versions := range rebuild {
+		if d, ok := h.estimate(src, newestVersion(versions).String()); ok {
+			estimates[src] = d
+			known = append(known, d)
+		} else {
+			unknown = append(unknown, src)
+		}
+	}
+	var median time.Duration
+	if len(known) > 0 {
+		sort.Slice(known, func(i, j int) bool { return known[i] < known[j] })
+		median = known[len(known)/2]
+	}
+	for _, src := range unknown {
+		estimates[src] = median
+	}
+	return estimates, len(unknown)
+}
+
+// makespan simulates building the sources in order with numJobs workers and
+// returns the expected total duration.
+func makespan(order []string, estimates map[string]time.Duration, numJobs int) time.Duration {
+	workers := make([]time.Duration, numJobs)
+	for _, src := range order {
+		next := 0
+		for i := range workers {
+			if workers[i] < workers[next] {
+				next = i
+			}
+		}
+		workers[next] += estimates[src]
+	}
+	var total time.Duration
+	for _, w := range workers {
+		total = max(total, w)
+	}
+	return total
+}
+
+// loadBuildHistoryFlag loads the build time database from -state-dir. Errors
+// are logged, and an empty database is returned.
+func loadBuildHistoryFlag() *buildHistory {
+	dir := *stateDir
+	if dir == "" {
+		dir = defaultStateDir()
+	}
+	h, err := loadBuildHistory(dir)
+	if err != nil {
+		log.Printf("Warning: could not read build time database %s: %v\n", h.path, err)
+		h.Sources = make(map[string][]buildRecord)
+	}
+	return h
+}
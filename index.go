@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/deb"
+	"pault.ag/go/debian/dependency"
+	"pault.ag/go/debian/version"
+)
+
+// readAptIndex returns the decompressed contents of an apt lists file.
+func readAptIndex(path string) ([]byte, error) {
+	catFile := exec.Command("/usr/lib/apt/apt-helper",
+		"cat-file",
+		path)
+	if lines, err := catFile.Output(); err == nil {
+		return lines, nil
+	}
+	// Fallback for older versions of apt-get. See
+	// <20160111171230.GA17291@debian.org> for context.
+	return os.ReadFile(path)
+}
+
+func loadSourceIndices(sourcesPaths []string) ([]control.SourceIndex, error) {
+	var sources []control.SourceIndex
+	for _, sourcesPath := range sourcesPaths {
+		log.Printf("Loading sources index %q\n", sourcesPath)
+		lines, err := readAptIndex(sourcesPath)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := control.ParseSourceIndex(bufio.NewReader(bytes.NewReader(lines)))
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parsing %s: %w", sourcesPath, err)
+		}
+		sources = append(sources, idx...)
+	}
+	return sources, nil
+}
+
+// binPkg is the subset of a binary package's control fields ratt needs for
+// dependency analysis, regardless of whether it was read from a Packages
+// index or from a .deb file.
+type binPkg struct {
+	Package      string
+	Source       string
+	Version      version.Version
+	Architecture string
+	Depends      dependency.Dependency
+	PreDepends   dependency.Dependency
+	Provides     dependency.Dependency
+	Breaks       dependency.Dependency
+	Conflicts    dependency.Dependency
+	// Filename is the path of the .deb file or its location in the archive.
+	Filename string
+}
+
+func optionalDependency(para control.Paragraph, field string) dependency.Dependency {
+	value, ok := para.Values[field]
+	if !ok {
+		return dependency.Dependency{}
+	}
+	dep, err := dependency.Parse(value)
+	if err != nil {
+		log.Printf("Warning: could not parse %s %q: %v", field, value, err)
+		return dependency.Dependency{}
+	}
+	return *dep
+}
+
+func newBinPkg(para control.Paragraph, pkg, source string, ver version.Version, arch, filename string) binPkg {
+	return binPkg{
+		Package:      pkg,
+		Source:       source,
+		Version:      ver,
+		Architecture: arch,
+		Depends:      optionalDependency(para, "Depends"),
+		PreDepends:   optionalDependency(para, "Pre-Depends"),
+		Provides:     optionalDependency(para, "Provides"),
+		Breaks:       optionalDependency(para, "Breaks"),
+		Conflicts:    optionalDependency(para, "Conflicts"),
+		Filename:     filename,
+	}
+}
+
+// loadBinaryIndices reads all Packages files and returns the binary packages
+// for arch (and arch:all).
+func loadBinaryIndices(packagesPaths []string, arch string) ([]binPkg, error) {
+	var pkgs []binPkg
+	for _, packagesPath := range packagesPaths {
+		log.Printf("Loading packages index %q\n", packagesPath)
+		lines, err := readAptIndex(packagesPath)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := control.ParseBinaryIndex(bufio.NewReader(bytes.NewReader(lines)))
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parsing %s: %w", packagesPath, err)
+		}
+		for _, bin := range idx {
+			binArch := bin.Architecture.String()
+			if binArch != arch && binArch != "all" {
+				continue
+			}
+			pkgs = append(pkgs, newBinPkg(bin.Paragraph, bin.Package, bin.SourcePackage(), bin.Version, binArch, bin.Filename))
+		}
+	}
+	return pkgs, nil
+}
+
+// loadDebs reads the control files of the given .deb files.
+func loadDebs(debs []string) ([]binPkg, error) {
+	var pkgs []binPkg
+	for _, path := range debs {
+		d, closer, err := deb.LoadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", path, err)
+		}
+		c := d.Control
+		// binNMUs specify the source version, e.g. “Source: foo (1.0-1)”.
+		source := strings.Fields(c.SourceName())[0]
+		pkgs = append(pkgs, newBinPkg(c.Paragraph, c.Package, source, c.Version, c.Architecture.String(), path))
+		closer()
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// lintianTagRe matches lintian output lines such as
+// “W: foo: missing-dependency-on-libc needed by usr/bin/foo”.
+var lintianTagRe = regexp.MustCompile(`^[EWIPX]: \S+( \w+)?: \S+`)
+
+// lintianTags returns the lintian tags from the Lintian section of an sbuild
+// log.
+func lintianTags(logFile string) (map[string]bool, error) {
+	lines, err := readLines(logFile)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]bool)
+	inSection := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "| ") {
+			inSection = strings.Contains(line, "Lintian")
+			continue
+		}
+		if inSection && lintianTagRe.MatchString(line) {
+			tags[strings.TrimSpace(line)] = true
+		}
+	}
+	return tags, nil
+}
+
+// addLintianRegressions compares the lintian tags of every successful build
+// with those of its baseline build.
+func addLintianRegressions(buildresults map[string]*buildResult) {
+	for src, result := range buildresults {
+		if result.err != nil || result.logFile == "" || result.baselineLogFile == "" || result.baselineChangesFile == "" {
+			continue
+		}
+		baseline, err := lintianTags(result.baselineLogFile)
+		if err != nil {
+			log.Printf("Could not read lintian tags of %s: %v\n", src, err)
+			continue
+		}
+		current, err := lintianTags(result.logFile)
+		if err != nil {
+			log.Printf("Could not read lintian tags of %s: %v\n", src, err)
+			continue
+		}
+		var added []string
+		for tag := range current {
+			if !baseline[tag] {
+				added = append(added, tag)
+			}
+		}
+		sort.Strings(added)
+		result.newLintianTags = added
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
+	"pault.ag/go/debian/version"
+)
+
+// migrationReport describes what would happen if the binaries of the .changes
+// files migrated from unstable to testing, similar to britney's analysis.
+type migrationReport struct {
+	// Uninstallable maps testing binaries which would become uninstallable
+	// to the reasons.
+	Uninstallable map[string][]string `json:"uninstallable"`
+	// Unbuildable maps testing sources whose build dependencies would
+	// become unsatisfiable to the reasons.
+	Unbuildable map[string][]string `json:"unbuildable"`
+	// MigrateTogether maps sources which need to migrate from unstable
+	// together with the new package to the reasons.
+	MigrateTogether map[string][]string `json:"migrate_together"`
+	// Rebuild lists sources which stay broken even with their unstable
+	// version and need to be rebuilt or fixed.
+	Rebuild []string `json:"rebuild"`
+	// Unsatisfiable lists dependencies of the new binaries which cannot be
+	// satisfied in unstable either, so the package would get stuck.
+	Unsatisfiable []string `json:"unsatisfiable"`
+}
+
+func relationStrings(rels []dependency.Relation) []string {
+	strs := make([]string, len(rels))
+	for i, r := range rels {
+		strs[i] = r.String()
+	}
+	return strs
+}
+
+func buildDependsOf(src control.SourceIndex) dependency.Dependency {
+	var dep dependency.Dependency
+	for _, d := range []dependency.Dependency{
+		src.GetBuildDepends(),
+		src.GetBuildDependsArch(),
+		src.GetBuildDependsIndep(),
+	} {
+		dep.Relations = append(dep.Relations, d.Relations...)
+	}
+	return dep
+}
+
+// simulateMigration computes the migrationReport for the given .debs, which
+// are built from ourSources.
+func simulateMigration(v *vendor, arch string, debs, ourSources []string) (*migrationReport, error) {
+	testingSources, testingPackages := getIndexPathsForDist(v, "testing", *useChdist)
+	unstableSources, unstablePackages := getIndexPathsForDist(v, "unstable", *useChdist)
+	if len(testingPackages) == 0 || len(unstablePackages) == 0 {
+		return nil, fmt.Errorf("could not find Packages files for both testing and unstable")
+	}
+
+	testingBins, err := loadBinaryIndices(testingPackages, arch)
+	if err != nil {
+		return nil, err
+	}
+	unstableBins, err := loadBinaryIndices(unstablePackages, arch)
+	if err != nil {
+		return nil, err
+	}
+	testingSrcs, err := loadSourceIndices(testingSources)
+	if err != nil {
+		return nil, err
+	}
+	unstableSrcs, err := loadSourceIndices(unstableSources)
+	if err != nil {
+		return nil, err
+	}
+	newBins, err := loadDebs(debs)
+	if err != nil {
+		return nil, err
+	}
+
+	ours := make(map[string]bool)
+	for _, src := range ourSources {
+		ours[src] = true
+	}
+	newNames := make(map[string]bool)
+	for _, bin := range newBins {
+		newNames[bin.Package] = true
+	}
+
+	before := newUniverse(arch, testingBins)
+	after := before.clone()
+	// Like britney, the old binaries of the migrating source are removed.
+	after.remove(func(pkg binPkg) bool {
+		return ours[pkg.Source] || newNames[pkg.Package]
+	})
+	for _, bin := range newBins {
+		after.add(bin)
+	}
+	unstable := newUniverse(arch, unstableBins)
+
+	report := &migrationReport{
+		Uninstallable:   make(map[string][]string),
+		Unbuildable:     make(map[string][]string),
+		MigrateTogether: make(map[string][]string),
+	}
+
+	// Dependencies of the new binaries which testing cannot satisfy.
+	for _, bin := range newBins {
+		for _, rel := range after.uninstallable(bin) {
+			var found bool
+			for _, p := range rel.Possibilities {
+				if cands := unstable.candidates(p); len(cands) > 0 {
+					report.MigrateTogether[cands[0].Source] = append(report.MigrateTogether[cands[0].Source],
+						fmt.Sprintf("%s depends on %s", bin.Package, rel))
+					found = true
+					break
+				}
+			}
+			if !found {
+				report.Unsatisfiable = append(report.Unsatisfiable,
+					fmt.Sprintf("%s depends on %s", bin.Package, rel))
+			}
+		}
+	}
+
+	// Testing binaries which become uninstallable, either because a
+	// dependency disappears or because a new binary Breaks them.
+	brokenSources := make(map[string]bool)
+	for _, pkg := range testingBins {
+		if ours[pkg.Source] {
+			continue
+		}
+		if len(before.uninstallable(pkg)) > 0 {
+			// Already broken in testing, not our problem.
+			continue
+		}
+		if rels := after.uninstallable(pkg); len(rels) > 0 {
+			report.Uninstallable[pkg.Package] = append(report.Uninstallable[pkg.Package],
+				relationStrings(rels)...)
+			brokenSources[pkg.Source] = true
+		}
+	}
+	for _, bin := range newBins {
+		for _, p := range bin.Breaks.GetAllPossibilities() {
+			for _, pkg := range before.candidates(p) {
+				if ours[pkg.Source] || pkg.Package != p.Name {
+					continue
+				}
+				report.Uninstallable[pkg.Package] = append(report.Uninstallable[pkg.Package],
+					fmt.Sprintf("%s %s is broken by %s (Breaks: %s)", pkg.Package, pkg.Version, bin.Package, p))
+				brokenSources[pkg.Source] = true
+			}
+		}
+	}
+
+	// Testing sources which can no longer be built.
+	for _, src := range testingSrcs {
+		if ours[src.Package] {
+			continue
+		}
+		bd := buildDependsOf(src)
+		if len(before.unsatisfied(bd)) > 0 {
+			continue
+		}
+		if rels := after.unsatisfied(bd); len(rels) > 0 {
+			report.Unbuildable[src.Package] = relationStrings(rels)
+			brokenSources[src.Package] = true
+		}
+	}
+
+	// For every broken source, check whether its unstable version would fix
+	// the breakage (migrate together) or whether it needs to be rebuilt.
+	unstableVersions := make(map[string]version.Version)
+	for _, src := range unstableSrcs {
+		if cur, ok := unstableVersions[src.Package]; !ok || version.Compare(src.Version, cur) > 0 {
+			unstableVersions[src.Package] = src.Version
+		}
+	}
+	testingVersions := make(map[string]version.Version)
+	for _, src := range testingSrcs {
+		testingVersions[src.Package] = src.Version
+	}
+	unstableBinsBySource := make(map[string][]binPkg)
+	for _, pkg := range unstableBins {
+		unstableBinsBySource[pkg.Source] = append(unstableBinsBySource[pkg.Source], pkg)
+	}
+	for src := range brokenSources {
+		uv, ok := unstableVersions[src]
+		if !ok || version.Compare(uv, testingVersions[src]) <= 0 {
+			report.Rebuild = append(report.Rebuild, src)
+			continue
+		}
+		fixed := true
+		for _, pkg := range unstableBinsBySource[src] {
+			if len(after.uninstallable(pkg)) > 0 || brokenByBreaks(pkg, newBins) {
+				fixed = false
+				break
+			}
+		}
+		if !fixed {
+			report.Rebuild = append(report.Rebuild, src)
+			continue
+		}
+		report.MigrateTogether[src] = append(report.MigrateTogether[src],
+			fmt.Sprintf("unstable version %s is compatible", uv))
+	}
+	sort.Strings(report.Rebuild)
+	sort.Strings(report.Unsatisfiable)
+	return report, nil
+}
+
+// brokenByBreaks reports whether any of newBins declares Breaks against pkg.
+func brokenByBreaks(pkg binPkg, newBins []binPkg) bool {
+	for _, bin := range newBins {
+		for _, p := range bin.Breaks.GetAllPossibilities() {
+			if p.Name == pkg.Package && (p.Version == nil || p.Version.SatisfiedBy(pkg.Version)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func printMigrationReport(report *migrationReport) {
+	if *jsonOutput {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to marshal JSON: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	log.Printf("Migration simulation results:\n")
+	for _, pkg := range sortedKeys(report.Uninstallable) {
+		for _, reason := range report.Uninstallable[pkg] {
+			log.Printf("UNINSTALLABLE: %s (%s)\n", pkg, reason)
+		}
+	}
+	for _, src := range sortedKeys(report.Unbuildable) {
+		for _, reason := range report.Unbuildable[src] {
+			log.Printf("UNBUILDABLE: %s (build-depends on %s)\n", src, reason)
+		}
+	}
+	for _, src := range sortedKeys(report.MigrateTogether) {
+		for _, reason := range report.MigrateTogether[src] {
+			log.Printf("MIGRATE TOGETHER: %s (%s)\n", src, reason)
+		}
+	}
+	for _, src := range report.Rebuild {
+		log.Printf("NEEDS REBUILD OR FIX: %s\n", src)
+	}
+	for _, dep := range report.Unsatisfiable {
+		log.Printf("STUCK: %s, not satisfiable in unstable either\n", dep)
+	}
+	if len(report.Uninstallable) == 0 && len(report.Unbuildable) == 0 &&
+		len(report.MigrateTogether) == 0 && len(report.Unsatisfiable) == 0 {
+		log.Printf("The new package could migrate to testing on its own\n")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// normalizeMirror turns a mirror setting into a URL usable in sources.list
+// entries: local paths, including relative ones, become file:// URLs and
+// trailing slashes are removed.
+func normalizeMirror(mirror string) string {
+	if mirror != "" && !strings.Contains(mirror, "://") {
+		if abs, err := filepath.Abs(mirror); err == nil {
+			mirror = abs
+		}
+		mirror = "file://" + mirror
+	}
+	return strings.TrimRight(mirror, "/")
+}
+
+// openMirrorFile opens path (e.g. “dists/sid/Release”) relative to mirror,
+// which may be an http(s):// or file:// URL.
+func openMirrorFile(mirror, path string) (io.ReadCloser, error) {
+	u, err := url.Parse(normalizeMirror(mirror))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror %q: %w", mirror, err)
+	}
+	if u.Scheme == "file" {
+		return os.Open(filepath.Join(u.Path, path))
+	}
+
+	resp, err := http.Get(u.String() + "/" + path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("received non-OK response for %s: %s", resp.Request.URL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// shellJoin joins a command line for display, quoting arguments so that the
+// result can be pasted into a shell.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_=+:,./@%~", r))
+		}) == -1 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"pault.ag/go/debian/control"
+)
+
+type localRelease struct {
+	Suite    string
+	Codename string
+}
+
+// aptListsDir returns the directory holding the apt lists (InRelease,
+// Sources, Packages, …) of either the host or the -chdist instance.
+func aptListsDir() string {
+	if *useChdist != "" {
+		return filepath.Join(os.Getenv("HOME"), ".chdist", *useChdist, "var/lib/apt/lists")
+	}
+	return "/var/lib/apt/lists"
+}
+
+// localReleases reads the Suite and Codename of all InRelease and Release
+// files in the apt lists directory, so that no network access is required.
+func localReleases() ([]localRelease, error) {
+	dir := aptListsDir()
+	var paths []string
+	for _, pattern := range []string{"*_InRelease", "*_Release"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no InRelease or Release files found in %s", dir)
+	}
+
+	var releases []localRelease
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var rel localRelease
+		err = control.Unmarshal(&rel, bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		releases = append(releases, rel)
+	}
+	return releases, nil
+}
+
+// localCodenameToSuite is the offline variant of codenameToSuite.
+func localCodenameToSuite(codename string) (string, error) {
+	releases, err := localReleases()
+	if err != nil {
+		return "", err
+	}
+	for _, rel := range releases {
+		if rel.Codename == codename {
+			return rel.Suite, nil
+		}
+	}
+	return "", fmt.Errorf("no suite found for codename %q in %s", codename, aptListsDir())
+}
+
+// localCodenameFromDist is the offline variant of fetchCodenameFromDist.
+func localCodenameFromDist(dist string) (string, error) {
+	releases, err := localReleases()
+	if err != nil {
+		return "", err
+	}
+	for _, rel := range releases {
+		if rel.Suite == dist || rel.Codename == dist {
+			return rel.Codename, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find a Release file for %s in %s", dist, aptListsDir())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pault.ag/go/debian/version"
+)
+
+// overridesFlag collects -override src=path arguments.
+type overridesFlag map[string]string
+
+func (o overridesFlag) String() string {
+	var pairs []string
+	for src, path := range o {
+		pairs = append(pairs, src+"="+path)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (o overridesFlag) Set(value string) error {
+	src, path, ok := strings.Cut(value, "=")
+	if !ok || src == "" || path == "" {
+		return fmt.Errorf("expected <source>=<path>, got %q", value)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	o[src] = abs
+	return nil
+}
+
+// isPatchSeries reports whether path is a patch file or a directory with a
+// quilt series file, as opposed to a .dsc or an unpacked source tree.
+func isPatchSeries(path string) bool {
+	if strings.HasSuffix(path, ".patch") || strings.HasSuffix(path, ".diff") {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(path, "debian", "changelog")); err == nil {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(path, "series"))
+	return err == nil
+}
+
+// patchFiles returns the patches of a patch series in order.
+func patchFiles(path string) ([]string, error) {
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	series, err := os.ReadFile(filepath.Join(path, "series"))
+	if err != nil {
+		return nil, err
+	}
+	var patches []string
+	for _, line := range strings.Split(string(series), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		patches = append(patches, filepath.Join(path, fields[0]))
+	}
+	return patches, nil
+}
+
+// fetchSource downloads and unpacks the archive source src_ver into dir and
+// returns the path of the unpacked tree.
+func fetchSource(src string, ver version.Version, dir string) (string, error) {
+	args := []string{"apt-get", "source", "--only-source", src + "=" + ver.String()}
+	if *useChdist != "" {
+		args = append([]string{"chdist", "apt-get", *useChdist}, args[1:]...)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %w", args, err)
+	}
+	upstream := ver.Version
+	matches, err := filepath.Glob(filepath.Join(dir, src+"-"+upstream))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) != 1 {
+		return "", fmt.Errorf("could not find unpacked source of %s_%s in %s", src, ver, dir)
+	}
+	return matches[0], nil
+}
+
+// applyPatches applies patches to the source tree in dir. For 3.0 (quilt)
+// sources, the patches are added to debian/patches so that dpkg-source
+// accepts them; otherwise they are applied with patch -p1.
+func applyPatches(dir string, patches []string) error {
+	format, _ := os.ReadFile(filepath.Join(dir, "debian", "source", "format"))
+	if strings.Contains(string(format), "quilt") {
+		patchDir := filepath.Join(dir, "debian", "patches")
+		if err := os.MkdirAll(patchDir, 0755); err != nil {
+			return err
+		}
+		series, err := os.OpenFile(filepath.Join(patchDir, "series"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer series.Close()
+		for _, patch := range patches {
+			name := "ratt-" + filepath.Base(patch)
+			if err := copyFile(patch, filepath.Join(patchDir, name)); err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintln(series, name); err != nil {
+				return err
+			}
+		}
+		return series.Close()
+	}
+
+	for _, patch := range patches {
+		cmd := exec.Command("patch", "-p1", "-i", patch)
+		cmd.Dir = dir
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("applying %s: %w", patch, err)
+		}
+	}
+	return nil
+}
+
+// prepareOverrides turns the -override arguments into sbuild targets (a .dsc
+// or a source directory). Patch series are applied on top of the archive
+// version of the source in rebuild; with -dry_run, they are returned in
+// patches instead, and the archive version stays the target.
+func prepareOverrides(overrides map[string]string, rebuild map[string][]version.Version) (targets, patches map[string]string) {
+	targets = make(map[string]string)
+	patches = make(map[string]string)
+	for src, path := range overrides {
+		versions, ok := rebuild[src]
+		if !ok {
+			log.Printf("Warning: -override for %s ignored, it is not a reverse-build-dependency that will be built\n", src)
+			continue
+		}
+		if !isPatchSeries(path) {
+			targets[src] = path
+			continue
+		}
+		if *dryRun {
+			log.Printf("Would apply patches from %s on top of %s_%s\n", path, src, newestVersion(versions))
+			patches[src] = path
+			continue
+		}
+		files, err := patchFiles(path)
+		if err != nil {
+			log.Fatalf("Could not read patch series %s: %v", path, err)
+		}
+		dir, err := os.MkdirTemp("", "ratt-override-"+src+"-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		tree, err := fetchSource(src, newestVersion(versions), dir)
+		if err != nil {
+			log.Fatalf("Could not fetch source of %s: %v", src, err)
+		}
+		if err := applyPatches(tree, files); err != nil {
+			log.Fatalf("Could not patch %s: %v", src, err)
+		}
+		log.Printf("Applied %d patches from %s to %s\n", len(files), path, tree)
+		targets[src] = tree
+	}
+	return targets, patches
+}
+
+// overrideNote returns a suffix for the build summary naming the override
+// which was built, if any.
+func (r *buildResult) overrideNote() string {
+	if r.override == "" {
+		return ""
+	}
+	return " (overridden by " + r.override + ")"
+}
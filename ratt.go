@@ -25,6 +25,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 	"pault.ag/go/archive"
@@ -43,12 +44,58 @@ func filter(m map[string][]version.Version, f func(string) bool) map[string][]ve
 }
 
 type buildResult struct {
-	src            string
-	version        *version.Version
-	err            error
-	recheckErr     error
+	src        string
+	version    *version.Version
+	err        error
+	recheckErr error
+	// rechecked is set if the package was rebuilt without the new
+	// packages (-recheck).
+	rechecked      bool
 	logFile        string
 	recheckLogFile string
+	changesFile    string
+	// certainFailure, if non-empty, explains why the build cannot succeed
+	// with the new packages (e.g. a dropped binary is build-depended on).
+	certainFailure string
+	// expectedBreak, if non-empty, names the Breaks/Conflicts declared by
+	// the new packages against this source's binaries.
+	expectedBreak string
+	// override, if non-empty, is the .dsc or source directory which was
+	// built instead of the archive version (see -override).
+	override string
+	// failureCategory and failureReason classify a failed build based on
+	// its log (see classifyBuildLog).
+	failureCategory string
+	failureReason   string
+	// excerpt holds the most relevant lines of the failed build log (see
+	// extractExcerpt).
+	excerpt []string
+	// envDiff lists the packages whose version differs between the
+	// -recheck build environment and the one with the new packages.
+	envDiff []packageChange
+	// testDiff compares the go test results with those of the -recheck
+	// build.
+	testDiff *goTestDiff
+	// apiBreaks explains compiler errors by removed or changed Go
+	// identifiers (see attributeAPIBreaks).
+	apiBreaks []string
+	// artifactDiffs lists significant differences between the produced
+	// .debs and the reference (see -compare-artifacts).
+	artifactDiffs []artifactDiff
+	// baselineLogFile and baselineChangesFile are the results of the
+	// build without the new packages (see buildBaselines).
+	baselineLogFile     string
+	baselineChangesFile string
+	// newLintianTags are the lintian tags which only appear with the new
+	// packages.
+	newLintianTags []string
+	// usage is the resource usage of the sbuild run, baselineUsage that of
+	// the successful baseline build, if any, and previousUsage that of the
+	// previous successful build of the same version in the build time
+	// database, if any.
+	usage         resourceUsage
+	baselineUsage *resourceUsage
+	previousUsage *resourceUsage
 }
 
 var (
@@ -106,7 +153,7 @@ var (
 
 	jsonOutput = flag.Bool("json",
 		false,
-		"Output results in JSON format (currently only works in combination with -dry_run)")
+		"Output results in JSON format to stdout: the sbuild commands with -dry_run, the migration report with -migration-check, or the build results otherwise")
 
 	parallel = flag.Bool("parallel",
 		false,
@@ -116,13 +163,86 @@ var (
 		runtime.NumCPU(),
 		"Number of parallel build jobs (default: number of CPU cores)")
 
+	outputDir = flag.String("output-dir",
+		"",
+		"Collect the .changes and .debs of all successful rebuilds in this directory and generate Packages/Sources/Release indices, so that it can be used as a local apt repository")
+
+	injectRepo = flag.String("inject-repo",
+		"",
+		"Inject the .debs via a local apt repository created in this directory (sbuild --extra-repository plus apt pinning) instead of --extra-package. The directory must be accessible from within the sbuild chroot")
+
+	mirror = flag.String("mirror",
+		"",
+		"Archive mirror used for sbuild --extra-repository entries and Release file lookups. Can be an http(s):// or file:// URL or a local path. Defaults to the vendor's mirror (e.g. http://deb.debian.org/debian)")
+
+	securityMirror = flag.String("security-mirror",
+		"",
+		"Security archive mirror used for the -security pocket. Can be an http(s):// or file:// URL or a local path. Defaults to the vendor's security mirror (e.g. http://deb.debian.org/debian-security)")
+
+	vendorName = flag.String("vendor",
+		"",
+		"Distribution vendor (\"debian\" or \"ubuntu\"), which determines mirrors, pockets and components. Defaults to guessing from the Distribution: entry of the .changes file")
+
+	offline = flag.Bool("offline",
+		false,
+		"Do not access the network: resolve suites and codenames from the local InRelease files under /var/lib/apt/lists (or the -chdist tree) and disable -skip_ftbfs")
+
+	migrationCheck = flag.Bool("migration-check",
+		false,
+		"Do not build anything, but simulate the migration of the new binaries from unstable to testing and report which testing packages would become uninstallable or unbuildable, and which packages would need to migrate together or be rebuilt")
+
+	transition = flag.Bool("transition",
+		false,
+		"Library transition mode: detect renamed shared library packages (e.g. libfoo1 -> libfoo2) and rebuild all sources with binaries depending on the old package as binNMUs, instead of the reverse-build-dependencies. The binNMU list is printed to stdout in wanna-build syntax")
+
+	benFile = flag.String("ben",
+		"",
+		"Write a ben(1) transition tracker definition (is_affected/is_good/is_bad) for the detected library transition to this file, annotated with the build results. Requires -transition")
+
+	goAPIDiff = flag.Bool("go-api-diff",
+		false,
+		"For Go libraries: compare the exported API of the new .debs with the archive version (downloaded from -mirror), log removed and changed identifiers, and attribute compiler errors of failed builds to them")
+
+	goImportFilter = flag.String("go-import-filter",
+		"",
+		"For Go libraries: check which packages changed between the archive and the new .debs, and \"skip\" the reverse-build-dependencies which import none of them, or \"defer\" them until all other packages are built")
+
+	artifactReference = flag.String("compare-artifacts",
+		"",
+		"Compare the control fields, file lists and sizes of the .debs of successful rebuilds with a \"baseline\" build without the new packages, or with the \"archive\" .debs, and report significant differences. Requires -output-dir")
+
+	diffoscope = flag.Bool("diffoscope",
+		false,
+		"Together with -compare-artifacts, write diffoscope(1) output for .debs which differ significantly to -log_dir")
+
+	lintian = flag.Bool("lintian",
+		false,
+		"Run lintian on each rebuilt package (sbuild --run-lintian), both with the new packages and in a baseline build without them, and report the tags which only appear with the new packages")
+
+	stateDir = flag.String("state-dir",
+		"",
+		"Directory for the build time database, which is used to estimate run times, to start the longest builds first and to detect slowdowns without a baseline build. Defaults to $XDG_STATE_HOME/ratt or ~/.local/state/ratt")
+
+	// overrides maps reverse-build-dependencies to a local .dsc, source
+	// directory or patch series to build instead of the archive version.
+	overrides = overridesFlag{}
+
 	listsPrefixRe = regexp.MustCompile(`/([^/]*_dists_.*)_InRelease$`)
 )
 
+func init() {
+	flag.Var(overrides, "override",
+		"Build <source>=<path> instead of the archive version of a reverse-build-dependency. path can be a .dsc file, an unpacked source directory, or a patch file or directory with a quilt series file to apply on top of the archive source. Can be given multiple times")
+}
+
 type dryRunBuild struct {
 	Package       string `json:"package"`
 	Version       string `json:"version"`
 	SbuildCommand string `json:"sbuild_command"`
+	Override      string `json:"override,omitempty"`
+	// EstimatedSeconds is the expected build duration, based on the build
+	// time database.
+	EstimatedSeconds float64 `json:"estimated_seconds,omitempty"`
 }
 
 type ftbfsBug struct {
@@ -177,15 +297,16 @@ func getFTBFSFromUDD(codename string) (map[string]struct{}, error) {
 }
 
 func fetchCodenameFromDist(dist string) (string, error) {
-	url := fmt.Sprintf("http://deb.debian.org/debian/dists/%s/Release", dist)
-
-	resp, err := http.Get(url)
+	if *offline {
+		return localCodenameFromDist(dist)
+	}
+	release, err := openMirrorFile(*mirror, "dists/"+dist+"/Release")
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch Release file: %w", err)
 	}
-	defer resp.Body.Close()
+	defer release.Close()
 
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := bufio.NewScanner(release)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "Codename: ") {
@@ -200,9 +321,13 @@ func fetchCodenameFromDist(dist string) (string, error) {
 }
 
 // Map a codename (for instance: "bookworm") to its suite ("stable", "oldstable", ...),
-// using the release metadata provided by pault.ag/go/archive
+// using the release metadata provided by pault.ag/go/archive (or the local apt
+// lists in -offline mode)
 func codenameToSuite(codename string) (string, error) {
-	suites := []string{"stable", "oldstable"}
+	if *offline {
+		return localCodenameToSuite(codename)
+	}
+	suites := []string{"stable", "oldstable", "oldoldstable", "testing"}
 	for _, s := range suites {
 		rel, _, err := archive.CachedRelease(s)
 		if err != nil {
@@ -227,23 +352,11 @@ func dependsOn(src control.SourceIndex, binaries map[string]bool) bool {
 
 func addReverseBuildDeps(sourcesPath string, binaries map[string]bool, rebuild map[string][]version.Version) error {
 	log.Printf("Loading sources index %q\n", sourcesPath)
-	catFile := exec.Command("/usr/lib/apt/apt-helper",
-		"cat-file",
-		sourcesPath)
-	var s *bufio.Reader
-	if lines, err := catFile.Output(); err == nil {
-		s = bufio.NewReader(bytes.NewReader(lines))
-	} else {
-		// Fallback for older versions of apt-get. See
-		// <20160111171230.GA17291@debian.org> for context.
-		o, err := os.Open(sourcesPath)
-		if err != nil {
-			return err
-		}
-		defer o.Close()
-		s = bufio.NewReader(o)
+	lines, err := readAptIndex(sourcesPath)
+	if err != nil {
+		return err
 	}
-	idx, err := control.ParseSourceIndex(s)
+	idx, err := control.ParseSourceIndex(bufio.NewReader(bytes.NewReader(lines)))
 	if err != nil && err != io.EOF {
 		return err
 	}
@@ -295,17 +408,21 @@ func resolveAptListFile(indexFilePath string) (string, error) {
 	return tmpFile.Name(), nil
 }
 
+func buildArch() string {
+	archOut, err := exec.Command("dpkg-architecture", "--query=DEB_BUILD_ARCH").Output()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return strings.TrimSpace(string(archOut))
+}
+
 func reverseBuildDeps(packagesPaths, sourcesPaths []string, binaries []string) (map[string][]version.Version, error) {
 	if _, err := exec.LookPath("dose-ceve"); err != nil {
 		log.Printf("dose-ceve(1) not found. Please install the dose-extra package for more accurate results. Falling back to interpreting Sources directly")
 		return fallback(sourcesPaths, binaries)
 	}
 
-	archOut, err := exec.Command("dpkg-architecture", "--query=DEB_BUILD_ARCH").Output()
-	if err != nil {
-		log.Fatal(err)
-	}
-	arch := strings.TrimSpace(string(archOut))
+	arch := buildArch()
 
 	// TODO: Cache this output based on the .changes file. dose-ceve takes quite a while.
 	ceve := exec.Command(
@@ -411,31 +528,8 @@ func fallbackIndexPaths() ([]string, []string) {
 	return sourcesPaths, packagesPaths
 }
 
-func getIndexPathsForDist(targetCodename, chdistInstance string) (sourcesPaths []string, packagesPaths []string) {
-	var indexCodenames []string
-	switch targetCodename {
-	case "unstable":
-		indexCodenames = []string{"sid"}
-	case "experimental":
-		indexCodenames = []string{"sid", "rc-buggy"}
-	default:
-		// treat as a concrete codename (for instance, "bookworm"). If it is part of
-		// stable or oldstable, include maintenance pockets (-updates/-security).
-		suite, err := codenameToSuite(targetCodename)
-		if err != nil {
-			log.Printf("Warning: could not resolve Suite for %q: %v (using only %q)",
-				targetCodename, err, targetCodename)
-			indexCodenames = []string{targetCodename}
-		} else if suite == "stable" || suite == "oldstable" {
-			indexCodenames = []string{
-				targetCodename,
-				targetCodename + "-updates",
-				targetCodename + "-security",
-			}
-		} else {
-			indexCodenames = []string{targetCodename}
-		}
-	}
+func getIndexPathsForDist(v *vendor, target, chdistInstance string) (sourcesPaths []string, packagesPaths []string) {
+	indexCodenames := resolveSuite(v, target).codenames()
 
 	for _, codename := range indexCodenames {
 		var srcs, pkgs []string
@@ -500,15 +594,31 @@ func getAptIndexPaths(dist string) ([]string, []string) {
 	return fallbackIndexPaths()
 }
 
-// returns the dist sbuild should use. we build experimental against unstable.
-func normalizeSbuildDist(dist string) string {
-	if dist == "experimental" {
-		return "unstable"
-	}
-	return dist
+// buildOrder returns the sources in rebuild sorted by their expected build
+// duration, longest first, so that no workers idle at the end. Deferred
+// sources come last.
+func buildOrder(rebuild map[string][]version.Version, deferred map[string]bool, estimates map[string]time.Duration) []string {
+	order := make([]string, 0, len(rebuild))
+	for src := range rebuild {
+		order = append(order, src)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if deferred[order[i]] != deferred[order[j]] {
+			return !deferred[order[i]]
+		}
+		if estimates[order[i]] != estimates[order[j]] {
+			return estimates[order[i]] > estimates[order[j]]
+		}
+		return order[i] < order[j]
+	})
+	return order
 }
 
-func buildPackages(builder *sbuild, rebuild map[string][]version.Version, numJobs int) (map[string]*buildResult, []dryRunBuild) {
+// buildPackages builds the sources in rebuild, starting them in the given
+// order. If haveHistory is set, i.e. the estimates are based on the build time
+// database for at least one source, the expected time of completion is
+// logged.
+func buildPackages(builder *sbuild, rebuild map[string][]version.Version, order []string, estimates map[string]time.Duration, haveHistory bool, numJobs int) (map[string]*buildResult, []dryRunBuild) {
 	var eg errgroup.Group
 	eg.SetLimit(numJobs)
 
@@ -517,8 +627,27 @@ func buildPackages(builder *sbuild, rebuild map[string][]version.Version, numJob
 	var resultsMu sync.Mutex
 	var cntMu sync.Mutex
 	cnt := 1
+	started := make(map[string]time.Time)
+
+	// eta returns the expected completion time: the remaining estimated
+	// duration of all unfinished builds, spread across the workers.
+	eta := func() time.Time {
+		var remaining time.Duration
+		for _, src := range order {
+			if _, ok := buildresults[src]; ok {
+				continue
+			}
+			if start, ok := started[src]; ok {
+				remaining += max(estimates[src]-time.Since(start), 0)
+			} else {
+				remaining += estimates[src]
+			}
+		}
+		return time.Now().Add(remaining / time.Duration(numJobs))
+	}
 
-	for src, versions := range rebuild {
+	for _, src := range order {
+		versions := rebuild[src]
 		eg.Go(func() error {
 			sort.Sort(sort.Reverse(version.Slice(versions)))
 			newest := versions[0]
@@ -528,6 +657,10 @@ func buildPackages(builder *sbuild, rebuild map[string][]version.Version, numJob
 			cnt++
 			cntMu.Unlock()
 
+			resultsMu.Lock()
+			started[src] = time.Now()
+			resultsMu.Unlock()
+
 			log.Printf("Building package %d of %d: %s\n", currentCnt, len(rebuild), src)
 			result := builder.build(src, &newest)
 			if result.err != nil {
@@ -537,12 +670,18 @@ func buildPackages(builder *sbuild, rebuild map[string][]version.Version, numJob
 			resultsMu.Lock()
 			defer resultsMu.Unlock()
 			buildresults[src] = result
+			if !*dryRun && haveHistory && len(buildresults) < len(rebuild) {
+				log.Printf("%d of %d packages done, expected to finish at %s\n",
+					len(buildresults), len(rebuild), eta().Format(time.Kitchen))
+			}
 			if *dryRun {
 				cmd := builder.buildCommandLine(src, &newest)
 				dryRunBuilds = append(dryRunBuilds, dryRunBuild{
-					Package:       src,
-					Version:       newest.String(),
-					SbuildCommand: strings.Join(cmd, " "),
+					Package:          src,
+					Version:          newest.String(),
+					SbuildCommand:    shellJoin(cmd),
+					Override:         result.override,
+					EstimatedSeconds: estimates[src].Seconds(),
 				})
 			}
 			return nil
@@ -559,8 +698,28 @@ func buildPackages(builder *sbuild, rebuild map[string][]version.Version, numJob
 func main() {
 	flag.Parse()
 
-	if *jsonOutput && !*dryRun {
-		log.Fatal("-json can only be used together with -dry_run")
+	if *benFile != "" && !*transition {
+		log.Fatal("-ben can only be used together with -transition")
+	}
+
+	if *goImportFilter != "" && *goImportFilter != "skip" && *goImportFilter != "defer" {
+		log.Fatalf("-go-import-filter must be \"skip\" or \"defer\", not %q", *goImportFilter)
+	}
+
+	if *artifactReference != "" && *artifactReference != "baseline" && *artifactReference != "archive" {
+		log.Fatalf("-compare-artifacts must be \"baseline\" or \"archive\", not %q", *artifactReference)
+	}
+
+	if *artifactReference != "" && *outputDir == "" {
+		log.Fatal("-compare-artifacts requires -output-dir")
+	}
+
+	if *diffoscope && *artifactReference == "" {
+		log.Fatal("-diffoscope can only be used together with -compare-artifacts")
+	}
+
+	if *lintian && *sbuildKeepBuildLog {
+		log.Fatal("-lintian reads the lintian output from the logs in -log_dir and cannot be used together with -sbuild-keep-build-log")
 	}
 
 	if *jobs <= 0 {
@@ -568,13 +727,22 @@ func main() {
 	}
 
 	if flag.NArg() == 0 {
-		log.Fatalf("Usage: %s [options] <path-to-changes-file>...\n", os.Args[0])
+		log.Fatalf("Usage: %s [options] <path-to-changes-file|.dsc|source-directory>...\n", os.Args[0])
 	}
 
+	if err := os.MkdirAll(*logDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	inputs := buildSourceInputs(flag.Args())
+
 	var debs []string
 	var binaries []string
+	var changesSources []string
+	var changesVersion string
 	var changesDist string
-	for i, changesPath := range flag.Args() {
+	var changesArchAll, changesArchAny bool
+	for i, changesPath := range inputs {
 		log.Printf("Loading changes file %q\n", changesPath)
 		c, err := os.Open(changesPath)
 		if err != nil {
@@ -594,9 +762,20 @@ func main() {
 			}
 		}
 		binaries = append(binaries, changes.Binaries...)
+		changesSources = append(changesSources, changes.Source)
+		for _, arch := range changes.Architectures {
+			switch arch.String() {
+			case "all":
+				changesArchAll = true
+			case "source":
+			default:
+				changesArchAny = true
+			}
+		}
 
 		if i == 0 {
 			changesDist = changes.Distribution
+			changesVersion = changes.Version.String()
 		} else if changesDist != changes.Distribution {
 			log.Printf("%s has different distrution, but we will only consider %s\n", changes.Filename, changesDist)
 		}
@@ -607,6 +786,24 @@ func main() {
 		log.Printf("    %s\n", deb)
 	}
 
+	v := lookupVendor(changesDist)
+	if *vendorName == "" {
+		log.Printf("Setting -vendor=%s (from .changes file)\n", v.name)
+	}
+	*mirror, *securityMirror = vendorMirrors(v)
+
+	if *migrationCheck {
+		if v.name != "debian" {
+			log.Fatalf("-migration-check is only supported for Debian (testing/unstable)")
+		}
+		report, err := simulateMigration(v, buildArch(), debs, changesSources)
+		if err != nil {
+			log.Fatalf("Could not simulate migration: %v", err)
+		}
+		printMigrationReport(report)
+		return
+	}
+
 	if strings.TrimSpace(*dist) == "" {
 		*dist = changesDist
 		// In the common workflow, we rebuild reverse build-deps from unstable even
@@ -622,9 +819,9 @@ func main() {
 	var sourcesPaths, packagesPaths []string
 
 	if *useChdist != "" {
-		sourcesPaths, packagesPaths = getIndexPathsForDist(*dist, *useChdist)
+		sourcesPaths, packagesPaths = getIndexPathsForDist(v, *dist, *useChdist)
 	} else {
-		sourcesPaths, packagesPaths = getIndexPathsForDist(*dist, "")
+		sourcesPaths, packagesPaths = getIndexPathsForDist(v, *dist, "")
 	}
 
 	if len(sourcesPaths) == 0 {
@@ -636,12 +833,76 @@ func main() {
 		}
 	}
 
-	rebuild, err := reverseBuildDeps(packagesPaths, sourcesPaths, binaries)
+	sources, err := loadSourceIndices(sourcesPaths)
 	if err != nil {
 		log.Fatal(err)
 	}
+	newBins, err := loadDebs(debs)
+	if err != nil {
+		log.Printf("Warning: could not read the .debs: %v", err)
+	}
 
-	if *skipFTBFS {
+	var rebuild map[string][]version.Version
+	var libTrans *libTransition
+	var certain map[string]string
+	if *transition {
+		libTrans = detectTransition(sources, changesSources, binaries, changesVersion)
+		if libTrans == nil {
+			log.Fatal("-transition: no renamed library packages found in the .changes files")
+		}
+		pkgs, err := loadBinaryIndices(packagesPaths, buildArch())
+		if err != nil {
+			log.Fatal(err)
+		}
+		rebuild = binNMUCandidates(pkgs, sources, libTrans.Old, changesSources)
+		printTransitionRequest(libTrans, rebuild, *dist)
+	} else {
+		lookup := binaries
+		dropped := droppedBinaries(sources, changesSources, binaries, changesArchAll, changesArchAny)
+		if len(dropped) > 0 {
+			log.Printf("Binaries dropped or renamed by the new version (also looking up their reverse-build-dependencies): %s\n", strings.Join(dropped, " "))
+			lookup = append(append([]string{}, binaries...), dropped...)
+		}
+		rebuild, err = reverseBuildDeps(packagesPaths, sourcesPaths, lookup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(dropped) > 0 {
+			arch := buildArch()
+			pkgs, err := loadBinaryIndices(packagesPaths, arch)
+			if err != nil {
+				log.Fatal(err)
+			}
+			certain = certainFailures(sources, pkgs, newBins, dropped, rebuild, arch)
+			for src, reason := range certain {
+				log.Printf("%s will certainly fail to build: %s\n", src, reason)
+			}
+		}
+	}
+
+	expected := expectedBreaks(sources, newBins, rebuild)
+	for src, reason := range expected {
+		log.Printf("%s is expected to break: %s\n", src, reason)
+	}
+
+	var goDebs []goDeb
+	var goDebsComplete bool
+	var apiChanges []goAPIChange
+	if (*goAPIDiff || *goImportFilter != "") && !*transition {
+		goDebs, goDebsComplete = archiveGoDebs(newBins, packagesPaths)
+	}
+	if *goAPIDiff && !*transition {
+		apiChanges = goAPIChanges(goDebs)
+		for _, change := range apiChanges {
+			log.Printf("Go API change: %s\n", change)
+		}
+	}
+
+	if *skipFTBFS && *offline {
+		log.Printf("Warning: -skip_ftbfs requires querying udd.debian.org, ignoring it in -offline mode")
+	} else if *skipFTBFS && !v.udd {
+		log.Printf("Warning: -skip_ftbfs is only supported for Debian, ignoring it for vendor %s", v.name)
+	} else if *skipFTBFS {
 		codename, err := fetchCodenameFromDist(*dist)
 		if err != nil {
 			log.Fatalf("Could not determine codename for dist %s: %v", *dist, err)
@@ -683,6 +944,33 @@ func main() {
 		log.Printf("Based on the supplied exclude filter, will only build %d reverse build dependencies\n", len(rebuild))
 	}
 
+	var unaffected map[string]string
+	deferred := make(map[string]bool)
+	if *goImportFilter != "" && !*transition {
+		pkgs, err := loadBinaryIndices(packagesPaths, buildArch())
+		if err != nil {
+			log.Fatal(err)
+		}
+		var indirect map[string]string
+		unaffected, indirect = filterByGoImports(sources, pkgs, binaries, rebuild, goDebs, goDebsComplete)
+		for src, reason := range indirect {
+			log.Printf("Deferring %s: %s\n", src, reason)
+			deferred[src] = true
+		}
+		for src, reason := range unaffected {
+			if *goImportFilter == "skip" {
+				log.Printf("Skipping %s: %s\n", src, reason)
+				delete(rebuild, src)
+			} else {
+				log.Printf("Deferring %s: %s\n", src, reason)
+				deferred[src] = true
+			}
+		}
+		if *goImportFilter == "defer" {
+			unaffected = nil
+		}
+	}
+
 	// TODO: add -recursive flag to also cover dependencies which are not DIRECT dependencies. use http://godoc.org/pault.ag/go/debian/control#OrderDSCForBuild (topsort) to build dependencies in the right order (saving CPU time).
 
 	// TODO: what’s a good integration method for doing this in more setups, e.g. on a cloud provider or something? mapreri from #debian-qa says jenkins.debian.net is suitable.
@@ -692,35 +980,58 @@ func main() {
 		log.Printf("Setting -sbuild_dist=%s (from .changes file)\n", *sbuildDist)
 	}
 
-	if err := os.MkdirAll(*logDir, 0755); err != nil {
-		log.Fatal(err)
+	if *outputDir != "" {
+		abs, err := filepath.Abs(*outputDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*outputDir = abs
+		if err := os.MkdirAll(*outputDir, 0755); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	sbuildDistNorm := normalizeSbuildDist(*sbuildDist)
 	extraExperimental := *sbuildDist == "experimental" && *sbuildExperimentalAspcud
-
-	// for stable/oldstable codenames, include maintenance pockets (-updates, -security)
-	extraPockets := false
-	pocketsCodename := ""
-	if suite, err := codenameToSuite(*sbuildDist); err == nil {
-		if suite == "stable" || suite == "oldstable" {
-			extraPockets = true
-			pocketsCodename = *sbuildDist
-		}
-	} else {
-		log.Printf("Warning: could not resolve Suite for %q: %v (no -updates/-security overlays)",
-			*sbuildDist, err)
+	sbuildTarget := *sbuildDist
+	if sbuildTarget == "experimental" && !extraExperimental {
+		sbuildTarget = "unstable"
 	}
+	sbuildSuite := resolveSuite(v, sbuildTarget)
+	log.Printf("Building in %s (pockets: %s)\n", sbuildSuite.chroot, strings.Join(sbuildSuite.codenames(), ", "))
 
 	builder := &sbuild{
-		dist:              sbuildDistNorm,
+		dist:              sbuildSuite.chroot,
 		logDir:            *logDir,
 		keepBuildLog:      *sbuildKeepBuildLog,
 		dryRun:            *dryRun,
 		extraDebs:         debs,
 		extraExperimental: extraExperimental,
-		extraPockets:      extraPockets,
-		pocketsCodename:   pocketsCodename,
+		suite:             sbuildSuite,
+		outputDir:         *outputDir,
+		mirror:            normalizeMirror(*mirror),
+		securityMirror:    normalizeMirror(*securityMirror),
+		runLintian:        *lintian,
+	}
+	if libTrans != nil {
+		builder.binNMU = libTrans.binNMUMessage()
+	}
+	if len(overrides) > 0 {
+		builder.overrides, builder.patchOverrides = prepareOverrides(overrides, rebuild)
+	}
+
+	if *injectRepo != "" {
+		abs, err := filepath.Abs(*injectRepo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*injectRepo = abs
+		if !*dryRun {
+			if err := createInjectionRepo(*injectRepo, debs); err != nil {
+				log.Fatalf("Could not create injection repository in %s: %v", *injectRepo, err)
+			}
+		}
+		builder.injectRepo = *injectRepo
+		log.Printf("Injecting .debs via local apt repository %s\n", *injectRepo)
 	}
 
 	buildresults := make(map[string](*buildResult))
@@ -731,7 +1042,37 @@ func main() {
 		numJobs = *jobs
 		log.Printf("Building packages in parallel using %d workers\n", numJobs)
 	}
-	buildresults, dryRunBuilds = buildPackages(builder, rebuild, numJobs)
+	history := loadBuildHistoryFlag()
+	estimates, unknown := history.buildEstimates(rebuild)
+	order := buildOrder(rebuild, deferred, estimates)
+	var estimatedTotal time.Duration
+	haveHistory := unknown < len(rebuild)
+	if haveHistory {
+		estimatedTotal = makespan(order, estimates, numJobs)
+		log.Printf("Estimated total build time: %s with %d workers (%d of %d packages without build time history)\n",
+			estimatedTotal.Round(time.Minute), numJobs, unknown, len(rebuild))
+	} else {
+		log.Printf("No build time history for any of the %d packages, cannot estimate the total build time\n", len(rebuild))
+	}
+	buildresults, dryRunBuilds = buildPackages(builder, rebuild, order, estimates, haveHistory, numJobs)
+	if !*dryRun {
+		history.addPreviousUsage(buildresults)
+		history.record(buildresults)
+		if err := history.save(); err != nil {
+			log.Printf("Warning: could not write build time database %s: %v\n", history.path, err)
+		}
+	}
+	for src, reason := range certain {
+		if result, ok := buildresults[src]; ok {
+			result.certainFailure = reason
+		}
+	}
+	for src, reason := range expected {
+		if result, ok := buildresults[src]; ok {
+			result.expectedBreak = reason
+		}
+	}
+	addAPIBreaks(buildresults, apiChanges)
 
 	var toInclude []string
 	for src, result := range buildresults {
@@ -743,13 +1084,19 @@ func main() {
 		log.Printf("%d packages failed the first pass; you can rerun ratt only for them passing the option -include '^(%s)$'\n", len(toInclude), strings.Join(toInclude, "|"))
 	}
 
+	if *dryRun {
+		writeBenFileFlag(libTrans, buildresults, true)
+	}
+
 	if *dryRun && *jsonOutput {
 		out, err := json.MarshalIndent(struct {
-			ReverseDepCount int           `json:"reverse_dep_count"`
-			Builds          []dryRunBuild `json:"dry_run_builds"`
+			ReverseDepCount       int           `json:"reverse_dep_count"`
+			Builds                []dryRunBuild `json:"dry_run_builds"`
+			EstimatedTotalSeconds float64       `json:"estimated_total_seconds,omitempty"`
 		}{
-			Builds:          dryRunBuilds,
-			ReverseDepCount: len(dryRunBuilds),
+			Builds:                dryRunBuilds,
+			ReverseDepCount:       len(dryRunBuilds),
+			EstimatedTotalSeconds: estimatedTotal.Seconds(),
 		}, "", "  ")
 		if err != nil {
 			log.Fatalf("Failed to marshal JSON: %v", err)
@@ -762,16 +1109,22 @@ func main() {
 		return
 	}
 
+	if *outputDir != "" {
+		publishOutputDir(*outputDir)
+	}
+
 	if *recheck {
 		log.Printf("Begin to rebuild all failed packages without new changes\n")
 		recheckBuilder := &sbuild{
-			dist:              sbuildDistNorm,
+			dist:              sbuildSuite.chroot,
 			logDir:            *logDir + "_recheck",
 			keepBuildLog:      *sbuildKeepBuildLog,
 			dryRun:            false,
 			extraExperimental: extraExperimental,
-			extraPockets:      extraPockets,
-			pocketsCodename:   pocketsCodename,
+			suite:             sbuildSuite,
+			mirror:            normalizeMirror(*mirror),
+			securityMirror:    normalizeMirror(*securityMirror),
+			overrides:         builder.overrides,
 		}
 		if err := os.MkdirAll(recheckBuilder.logDir, 0755); err != nil {
 			log.Fatal(err)
@@ -784,6 +1137,7 @@ func main() {
 			log.Printf("Rebuilding package %d of %d: %s \n", cnt, len(toInclude), src)
 			cnt++
 			recheckResult := recheckBuilder.build(src, result.version)
+			result.rechecked = true
 			result.recheckErr = recheckResult.err
 			result.recheckLogFile = recheckResult.logFile
 			if recheckResult.err != nil {
@@ -792,29 +1146,123 @@ func main() {
 		}
 	}
 
+	if *artifactReference == "baseline" || *lintian {
+		baselineBuilder := &sbuild{
+			dist:              sbuildSuite.chroot,
+			logDir:            *logDir + "_baseline",
+			keepBuildLog:      *sbuildKeepBuildLog,
+			extraExperimental: extraExperimental,
+			suite:             sbuildSuite,
+			outputDir:         filepath.Join(*logDir+"_baseline", "output"),
+			mirror:            normalizeMirror(*mirror),
+			securityMirror:    normalizeMirror(*securityMirror),
+			overrides:         builder.overrides,
+			runLintian:        *lintian,
+		}
+		if *outputDir != "" {
+			baselineBuilder.outputDir = *outputDir + "_baseline"
+		}
+		for _, dir := range []string{baselineBuilder.logDir, baselineBuilder.outputDir} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Fatal(err)
+			}
+		}
+		buildBaselines(buildresults, baselineBuilder)
+	}
+
+	if *lintian {
+		addLintianRegressions(buildresults)
+	}
+
+	if *artifactReference != "" {
+		var pkgs []binPkg
+		if *artifactReference == "archive" {
+			pkgs, err = loadBinaryIndices(packagesPaths, buildArch())
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		ourSources := make(map[string]bool)
+		for _, src := range changesSources {
+			ourSources[src] = true
+		}
+		diffoscopeDir := ""
+		if *diffoscope {
+			diffoscopeDir = *logDir
+		}
+		compareArtifacts(buildresults, pkgs, ourSources, diffoscopeDir)
+	}
+
+	if *recheck {
+		addBuildEnvDiffs(buildresults, binaries)
+		addGoTestDiffs(buildresults)
+	}
+
+	writeBenFileFlag(libTrans, buildresults, false)
+
 	log.Printf("Build results:\n")
 	// Print all successful builds first (not as interesting), then failed ones.
 	for src, result := range buildresults {
 		if result.err == nil {
-			log.Printf("PASSED: %s\n", src)
+			log.Printf("PASSED: %s%s (%s)\n", src, result.overrideNote(), result.usage)
+			result.printArtifactDiffs()
+			for _, tag := range result.newLintianTags {
+				log.Printf("    new lintian tag: %s\n", tag)
+			}
 		}
 	}
 
 	for src, result := range buildresults {
 		if result.err != nil && result.recheckErr != nil {
-			log.Printf("FAILED: %s, but maybe unrelated to new changes (see %s and %s)\n",
-				src, result.logFile, result.recheckLogFile)
+			log.Printf("FAILED: %s%s%s, but maybe unrelated to new changes (see %s and %s)\n",
+				src, result.overrideNote(), result.failureNote(), result.logFile, result.recheckLogFile)
+			result.printExcerpt()
+			result.printTestDiff()
 		}
 	}
 
+	// Failures of packages our packages declare Breaks against are expected
+	// and do not count as regressions.
+	for src, result := range buildresults {
+		if result.err != nil && result.recheckErr == nil && result.expectedBreak != "" {
+			log.Printf("EXPECTED-BREAK: %s%s, %s (see %s)\n", src, result.overrideNote(), result.expectedBreak, result.logFile)
+		}
+	}
+
+	printSlowdowns(buildresults)
+
+	for _, src := range sortedKeys(unaffected) {
+		log.Printf("SKIPPED: %s, %s\n", src, unaffected[src])
+	}
+
 	failures := false
 	for src, result := range buildresults {
-		if result.err != nil && result.recheckErr == nil {
-			log.Printf("FAILED: %s (see %s)\n", src, result.logFile)
+		if result.err != nil && result.recheckErr == nil && result.expectedBreak == "" {
+			if result.certainFailure != "" {
+				log.Printf("FAILED: %s%s, certain failure: %s (see %s; %s)\n", src, result.overrideNote(), result.certainFailure, result.logFile, result.usage)
+			} else {
+				log.Printf("FAILED: %s%s%s (see %s; %s)\n", src, result.overrideNote(), result.failureNote(), result.logFile, result.usage)
+			}
+			for _, reason := range result.apiBreaks {
+				log.Printf("    %s\n", reason)
+			}
+			result.printExcerpt()
+			result.printTestDiff()
+			result.printEnvDiff()
 			failures = true
 		}
 	}
 
+	var suggestions map[string][]string
+	if *recheck {
+		suggestions = suggestBreaks(sources, binaries, buildresults)
+	}
+	if *jsonOutput {
+		printBuildResultsJSON(buildresults, suggestions, apiChanges, unaffected)
+	} else {
+		printBreaksSuggestions(suggestions)
+	}
+
 	if failures {
 		os.Exit(1)
 	}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"pault.ag/go/debian/control"
+)
+
+// collectArtifacts copies the .changes file(s) sbuild left in buildDir,
+// together with all files they reference, into outputDir. It returns the new
+// path of the (last) .changes file.
+func collectArtifacts(buildDir, outputDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(buildDir, "*.changes"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no .changes file found in %s", buildDir)
+	}
+	var changesFile string
+	for _, changesPath := range matches {
+		changes, err := control.ParseChangesFile(changesPath)
+		if err != nil {
+			return "", fmt.Errorf("parsing %s: %w", changesPath, err)
+		}
+		if err := changes.Copy(outputDir); err != nil {
+			return "", fmt.Errorf("copying %s to %s: %w", changesPath, outputDir, err)
+		}
+		changesFile = changes.Filename
+	}
+	return changesFile, nil
+}
+
+// writeAptIndices turns dir into a flat apt repository by generating
+// Packages, Sources and Release files with apt-ftparchive(1). The result can
+// be used with a sources.list entry like “deb [trusted=yes] file:/dir ./”.
+func writeAptIndices(dir string) error {
+	if _, err := exec.LookPath("apt-ftparchive"); err != nil {
+		return fmt.Errorf("apt-ftparchive(1) not found. Please install the apt-utils package: %w", err)
+	}
+	for _, index := range []struct {
+		command  string
+		filename string
+	}{
+		{"packages", "Packages"},
+		{"sources", "Sources"},
+		// Release must come last, it contains checksums of the other indices.
+		{"release", "Release"},
+	} {
+		if err := aptFtparchive(dir, index.command, index.filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func aptFtparchive(dir, command, filename string) error {
+	// Write to a temporary file first so that apt-ftparchive release does
+	// not pick up a half-written index.
+	out, err := os.CreateTemp(dir, "."+filename+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	cmd := exec.Command("apt-ftparchive", command, ".")
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		out.Close()
+		return fmt.Errorf("apt-ftparchive %s: %w", command, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), filepath.Join(dir, filename))
+}
+
+// publishOutputDir generates the apt indices for outputDir and tells the user
+// how to consume the resulting repository.
+func publishOutputDir(outputDir string) {
+	if err := writeAptIndices(outputDir); err != nil {
+		log.Printf("Warning: could not generate apt indices in %s: %v", outputDir, err)
+		return
+	}
+	log.Printf("Rebuilt packages are available as a local apt repository:\n")
+	log.Printf("    deb [trusted=yes] file:%s ./\n", outputDir)
+}
+
+// createInjectionRepo copies debs into dir and turns dir into a flat apt
+// repository, which is then added to the build chroot via
+// sbuild --extra-repository instead of passing each .deb via --extra-package.
+func createInjectionRepo(dir string, debs []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, deb := range debs {
+		if err := copyFile(deb, filepath.Join(dir, filepath.Base(deb))); err != nil {
+			return err
+		}
+	}
+	return writeAptIndices(dir)
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Build result states, as used in the JSON output.
+const (
+	statusPassed          = "passed"
+	statusFailed          = "failed"
+	statusFailedUnrelated = "failed-unrelated"
+	statusExpectedBreak   = "expected-break"
+)
+
+// status returns how the summary treats r.
+func (r *buildResult) status() string {
+	switch {
+	case r.err == nil:
+		return statusPassed
+	case r.recheckErr != nil:
+		return statusFailedUnrelated
+	case r.expectedBreak != "":
+		return statusExpectedBreak
+	}
+	return statusFailed
+}
+
+// failureNote returns a suffix for the build summary with the failure
+// category and reason, if known.
+func (r *buildResult) failureNote() string {
+	if r.failureCategory == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [%s: %s]", r.failureCategory, r.failureReason)
+}
+
+// printExcerpt logs the excerpt of a failed build log, indented below the
+// summary line.
+func (r *buildResult) printExcerpt() {
+	for _, line := range r.excerpt {
+		log.Printf("    | %s\n", line)
+	}
+}
+
+type buildResultJSON struct {
+	Package         string   `json:"package"`
+	Version         string   `json:"version"`
+	Status          string   `json:"status"`
+	LogFile         string   `json:"log_file,omitempty"`
+	RecheckLogFile  string   `json:"recheck_log_file,omitempty"`
+	ChangesFile     string   `json:"changes_file,omitempty"`
+	Override        string   `json:"override,omitempty"`
+	FailureCategory string   `json:"failure_category,omitempty"`
+	FailureReason   string   `json:"failure_reason,omitempty"`
+	CertainFailure  string   `json:"certain_failure,omitempty"`
+	ExpectedBreak   string   `json:"expected_break,omitempty"`
+	Excerpt         []string `json:"excerpt,omitempty"`
+	// BuildEnvDiff is only set for failures which passed in -recheck.
+	BuildEnvDiff []packageChange `json:"build_env_diff,omitempty"`
+	TestDiff     *goTestDiff     `json:"test_diff,omitempty"`
+	APIBreaks    []string        `json:"api_breaks,omitempty"`
+	// ArtifactDiffs is only set for successful builds with
+	// -compare-artifacts.
+	ArtifactDiffs   []artifactDiff `json:"artifact_diffs,omitempty"`
+	BaselineLogFile string         `json:"baseline_log_file,omitempty"`
+	NewLintianTags  []string       `json:"new_lintian_tags,omitempty"`
+	WallSeconds     float64        `json:"wall_seconds"`
+	CPUSeconds      float64        `json:"cpu_seconds"`
+	// MaxRSSKiB is the RSS of the largest process (see resourceUsage).
+	MaxRSSKiB int64 `json:"max_rss_kib"`
+	// BaselineWallSeconds and BaselineCPUSeconds are set if a successful
+	// baseline build exists.
+	BaselineWallSeconds float64 `json:"baseline_wall_seconds,omitempty"`
+	BaselineCPUSeconds  float64 `json:"baseline_cpu_seconds,omitempty"`
+	// PreviousCPUSeconds is set if the build time database has a previous
+	// successful build of the same version.
+	PreviousCPUSeconds float64 `json:"previous_cpu_seconds,omitempty"`
+	Slowdown           bool    `json:"slowdown,omitempty"`
+}
+
+func (r *buildResult) toJSON() buildResultJSON {
+	j := buildResultJSON{
+		Package:         r.src,
+		Status:          r.status(),
+		LogFile:         r.logFile,
+		RecheckLogFile:  r.recheckLogFile,
+		ChangesFile:     r.changesFile,
+		Override:        r.override,
+		FailureCategory: r.failureCategory,
+		FailureReason:   r.failureReason,
+		CertainFailure:  r.certainFailure,
+		ExpectedBreak:   r.expectedBreak,
+		Excerpt:         r.excerpt,
+		BuildEnvDiff:    r.envDiff,
+		TestDiff:        r.testDiff,
+		APIBreaks:       r.apiBreaks,
+		ArtifactDiffs:   r.artifactDiffs,
+		BaselineLogFile: r.baselineLogFile,
+		NewLintianTags:  r.newLintianTags,
+		WallSeconds:     r.usage.wall.Seconds(),
+		CPUSeconds:      r.usage.cpu.Seconds(),
+		MaxRSSKiB:       r.usage.maxRSS,
+		Slowdown:        r.slowdown(),
+	}
+	if r.version != nil {
+		j.Version = r.version.String()
+	}
+	if r.baselineUsage != nil {
+		j.BaselineWallSeconds = r.baselineUsage.wall.Seconds()
+		j.BaselineCPUSeconds = r.baselineUsage.cpu.Seconds()
+	}
+	if r.previousUsage != nil {
+		j.PreviousCPUSeconds = r.previousUsage.cpu.Seconds()
+	}
+	return j
+}
+
+// printBuildResultsJSON prints the build results, sorted by package name, the
+// suggested Breaks (see suggestBreaks), the Go API changes and the packages
+// skipped by -go-import-filter as JSON to stdout.
+func printBuildResultsJSON(buildresults map[string]*buildResult, suggestions map[string][]string, apiChanges []goAPIChange, skipped map[string]string) {
+	results := make([]buildResultJSON, 0, len(buildresults))
+	for _, result := range buildresults {
+		results = append(results, result.toJSON())
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Package < results[j].Package
+	})
+	out, err := json.MarshalIndent(struct {
+		Builds          []buildResultJSON   `json:"builds"`
+		SuggestedBreaks map[string][]string `json:"suggested_breaks,omitempty"`
+		GoAPIChanges    []goAPIChange       `json:"go_api_changes,omitempty"`
+		Skipped         map[string]string   `json:"skipped,omitempty"`
+	}{
+		Builds:          results,
+		SuggestedBreaks: suggestions,
+		GoAPIChanges:    apiChanges,
+		Skipped:         skipped,
+	}, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal JSON: %v", err)
+	}
+	fmt.Println(string(out))
+}
@@ -12,94 +12,225 @@ import (
 )
 
 type sbuild struct {
-	dist      string
-	logDir    string
-	dryRun    bool
-	keepBuildLog  bool
-	extraDebs []string
+	dist              string
+	logDir            string
+	dryRun            bool
+	keepBuildLog      bool
+	extraDebs         []string
 	extraExperimental bool
-	extraPockets bool
-	pocketsCodename string
+	// suite provides the --extra-repository entries for all pockets of the
+	// target (e.g. -updates and -security for stable).
+	suite *suiteModel
+	// outputDir, if non-empty, is where the .changes and .debs of successful
+	// builds are collected.
+	outputDir string
+	// injectRepo, if non-empty, is a local apt repository containing
+	// extraDebs. It is added via --extra-repository and pinned above the
+	// archive instead of passing each .deb via --extra-package.
+	injectRepo string
+	// mirror and securityMirror are the archive URLs used for
+	// --extra-repository entries.
+	mirror         string
+	securityMirror string
+	// binNMU, if non-empty, is the changelog entry for building binNMUs
+	// instead of sourceful rebuilds.
+	binNMU string
+	// overrides maps source package names to a .dsc or source directory
+	// which is built instead of the archive version.
+	overrides map[string]string
+	// patchOverrides maps source package names to a patch series which a
+	// real run would apply before building (-dry_run only).
+	patchOverrides map[string]string
+	// runLintian makes sbuild run lintian on the built packages; the output
+	// ends up in the build log.
+	runLintian bool
+}
+
+// debNameVersion extracts package name and version from a .deb file name
+// such as “foo_1.0-1_amd64.deb”.
+func debNameVersion(filename string) (name, version string, ok bool) {
+	base := strings.TrimSuffix(filepath.Base(filename), ".deb")
+	parts := strings.Split(base, "_")
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// aptPreferencesArg returns an sbuild argument which writes an apt_preferences(5)
+// stanza to /etc/apt/preferences.d/<name> before the build dependencies are
+// installed. Newlines in stanza must be written as “\n”.
+func aptPreferencesArg(name, stanza string) string {
+	return "--chroot-setup-commands=printf '" + stanza + "' > /etc/apt/preferences.d/" + name
+}
+
+// injectRepoArgs returns the sbuild arguments which make the packages in
+// s.injectRepo available and preferred over any archive version, including
+// higher ones.
+func (s *sbuild) injectRepoArgs() []string {
+	var names []string
+	for _, filename := range s.extraDebs {
+		if name, _, ok := debNameVersion(filename); ok {
+			names = append(names, name)
+		}
+	}
+	// apt matches local (file:) repositories with an empty origin.
+	pin := fmt.Sprintf(`Package: %s\nPin: origin ""\nPin-Priority: 1001\n`, strings.Join(names, " "))
+	return []string{
+		"--extra-repository=deb [trusted=yes] file://" + s.injectRepo + " ./",
+		aptPreferencesArg("ratt", pin),
+	}
 }
 
 func (s *sbuild) buildCommandLine(sourcePackage string, version *version.Version) []string {
-	target := fmt.Sprintf("%s_%s", sourcePackage, version)
-	// TODO: discard resulting package immediately?
+	if override, ok := s.overrides[sourcePackage]; ok {
+		return s.commandLine(override)
+	}
+	return s.commandLine(fmt.Sprintf("%s_%s", sourcePackage, version))
+}
+
+// commandLine returns the sbuild command line for target, which can be a
+// source package name with version, a .dsc file or a source directory.
+func (s *sbuild) commandLine(target string) []string {
+	// binNMUs only rebuild architecture-dependent packages.
+	archAll := "--arch-all"
+	if s.binNMU != "" {
+		archAll = "--no-arch-all"
+	}
 	cmd := []string{
 		"sbuild",
-		"--arch-all",
+		archAll,
 		"--dist=" + s.dist,
 	}
-	switch {
-	case s.extraPockets && s.pocketsCodename != "":
-		cmd = append(cmd,
-			"--extra-repository='deb-src http://deb.debian.org/debian "+s.pocketsCodename+" main'",
-			"--extra-repository='deb-src http://deb.debian.org/debian "+s.pocketsCodename+"-updates main'",
-			"--extra-repository='deb-src http://deb.debian.org/debian-security "+s.pocketsCodename+"-security main'",
-		)
-	case s.extraExperimental:
+	if s.suite != nil {
+		cmd = append(cmd, s.suite.extraRepositories(s.mirror, s.securityMirror)...)
+		cmd = append(cmd, s.suite.pinningArgs()...)
+	}
+	if s.extraExperimental {
 		cmd = append(cmd,
-			"--extra-repository='deb-src http://deb.debian.org/debian unstable main'",
-			"--extra-repository='deb http://deb.debian.org/debian experimental main'",
-			"--extra-repository='deb-src http://deb.debian.org/debian experimental main'",
 			"--build-dep-resolver=aspcud",
-			"--aspcud-criteria='-count(down),-count(changed,APT-Release:=/experimental/),-removed,-changed,-new'",
+			"--aspcud-criteria=-count(down),-count(changed,APT-Release:=/experimental/),-removed,-changed,-new",
 		)
 		// force installation of provided extra packages by adding them as build-deps
 		for _, filename := range s.extraDebs {
-			base := filepath.Base(filename)
-			if strings.HasSuffix(base, ".deb") {
-				base = strings.TrimSuffix(base, ".deb")
-			}
-			parts := strings.Split(base, "_")
-			if len(parts) >= 2 {
-				pkgName := parts[0]
-				pkgVer := parts[1]
-				arg := fmt.Sprintf("--add-depends='%s (= %s)'", pkgName, pkgVer)
-				cmd = append(cmd, arg)
+			if pkgName, pkgVer, ok := debNameVersion(filename); ok {
+				cmd = append(cmd, fmt.Sprintf("--add-depends=%s (= %s)", pkgName, pkgVer))
 			}
 		}
 	}
+	if s.injectRepo != "" && len(s.extraDebs) > 0 {
+		cmd = append(cmd, s.injectRepoArgs()...)
+	}
+	if s.binNMU != "" {
+		cmd = append(cmd, "--make-binNMU="+s.binNMU, "--binNMU=1")
+	}
+	if s.runLintian {
+		cmd = append(cmd, "--run-lintian")
+	}
 	if !s.keepBuildLog {
 		cmd = append(cmd, "--nolog")
 	}
 	cmd = append(cmd, target)
-	for _, filename := range s.extraDebs {
-		cmd = append(cmd, fmt.Sprintf("--extra-package=%s", filename))
+	if s.injectRepo == "" {
+		for _, filename := range s.extraDebs {
+			cmd = append(cmd, fmt.Sprintf("--extra-package=%s", filename))
+		}
 	}
 	return cmd
 }
 
 func (s *sbuild) build(sourcePackage string, version *version.Version) *buildResult {
 	result := &buildResult{
-		src:     sourcePackage,
-		version: version,
+		src:      sourcePackage,
+		version:  version,
+		override: s.overrides[sourcePackage],
+	}
+	if patches, ok := s.patchOverrides[sourcePackage]; ok {
+		result.override = patches
 	}
 	commandLine := s.buildCommandLine(sourcePackage, version)
 	if s.dryRun {
-		log.Printf("  commandline: %v\n", commandLine)
+		log.Printf("  commandline: %s\n", shellJoin(commandLine))
 		return result
 	}
 
-	cmd := exec.Command(commandLine[0], commandLine[1:]...)
 	target := fmt.Sprintf("%s_%s", sourcePackage, version)
 
-	if !s.keepBuildLog {
-		buildlog, err := os.Create(filepath.Join(s.logDir, target))
+	var buildDir string
+	if s.outputDir != "" {
+		var err error
+		buildDir, err = os.MkdirTemp("", "ratt-"+sourcePackage+"-")
 		if err != nil {
 			result.err = err
 			return result
 		}
-		defer buildlog.Close()
-		cmd.Stdout = buildlog
-		cmd.Stderr = buildlog
-	} else {
+		commandLine = append(commandLine, "--build-dir="+buildDir)
+	}
+	result.logFile, result.usage, result.err = s.run(commandLine, target)
+	if result.err != nil && result.logFile != "" {
+		result.failureCategory, result.failureReason = classifyBuildLog(result.logFile)
+		result.excerpt = extractExcerpt(result.logFile, result.failureCategory)
+	}
+	if buildDir != "" {
+		if result.err != nil {
+			log.Printf("Keeping build directory of failed build %s: %s\n", target, buildDir)
+			return result
+		}
+		changesFile, err := collectArtifacts(buildDir, s.outputDir)
+		if err != nil {
+			log.Printf("Could not collect build artifacts of %s: %v\n", target, err)
+			return result
+		}
+		result.changesFile = changesFile
+		os.RemoveAll(buildDir)
+	}
+	return result
+}
+
+// run executes commandLine and measures its resource usage. Unless
+// keepBuildLog is set, the output is saved as logName in s.logDir, whose path
+// is returned. With -json, stdout is reserved for the JSON output, so the
+// sbuild output goes to stderr.
+func (s *sbuild) run(commandLine []string, logName string) (string, resourceUsage, error) {
+	cmd := exec.Command(commandLine[0], commandLine[1:]...)
+	if s.keepBuildLog {
 		cmd.Stdout = os.Stdout
+		if *jsonOutput {
+			cmd.Stdout = os.Stderr
+		}
 		cmd.Stderr = os.Stderr
+		usage, err := runMeasured(cmd)
+		return "", usage, err
 	}
-	result.err = cmd.Run()
-	if !s.keepBuildLog {
-		result.logFile = filepath.Join(s.logDir, target)
+	logFile := filepath.Join(s.logDir, logName)
+	buildlog, err := os.Create(logFile)
+	if err != nil {
+		return "", resourceUsage{}, err
 	}
-	return result
+	defer buildlog.Close()
+	cmd.Stdout = buildlog
+	cmd.Stderr = buildlog
+	usage, err := runMeasured(cmd)
+	return logFile, usage, err
+}
+
+// buildSource builds source, a .dsc file or an unpacked source directory,
+// and returns the path of the resulting .changes file in resultDir.
+func (s *sbuild) buildSource(source, resultDir string) (changesFile, logFile string, err error) {
+	commandLine := append(s.commandLine(source), "--build-dir="+resultDir)
+	log.Printf("Building %s: %s\n", source, shellJoin(commandLine))
+	logFile, _, err = s.run(commandLine, "target_"+filepath.Base(strings.TrimSuffix(source, ".dsc")))
+	if err != nil {
+		return "", logFile, err
+	}
+	matches, err := filepath.Glob(filepath.Join(resultDir, "*.changes"))
+	if err != nil {
+		return "", logFile, err
+	}
+	for _, match := range matches {
+		if !strings.HasSuffix(match, "_source.changes") {
+			return match, logFile, nil
+		}
+	}
+	return "", logFile, fmt.Errorf("no binary .changes file found in %s", resultDir)
 }
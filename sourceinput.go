@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"pault.ag/go/debian/control"
+)
+
+// sourceInput checks whether path is a source package rather than a binary
+// .changes file: an unpacked source directory, a .dsc file or a source-only
+// .changes file. It returns what to pass to sbuild and the target
+// distribution, if known.
+func sourceInput(path string) (source, dist string, ok bool, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", "", false, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	switch {
+	case fi.IsDir():
+		changelog := filepath.Join(abs, "debian", "changelog")
+		out, err := exec.Command("dpkg-parsechangelog", "-l", changelog, "-S", "Distribution").Output()
+		if err != nil {
+			return "", "", false, fmt.Errorf("%s is not an unpacked source package: dpkg-parsechangelog: %w", path, err)
+		}
+		return abs, strings.TrimSpace(string(out)), true, nil
+
+	case strings.HasSuffix(path, ".dsc"):
+		return abs, "", true, nil
+
+	case strings.HasSuffix(path, ".changes"):
+		changes, err := control.ParseChangesFile(abs)
+		if err != nil {
+			return "", "", false, err
+		}
+		for _, file := range changes.Files {
+			if filepath.Ext(file.Filename) == ".deb" {
+				return "", "", false, nil
+			}
+		}
+		for _, file := range changes.AbsFiles() {
+			if strings.HasSuffix(file.Filename, ".dsc") {
+				return file.Filename, changes.Distribution, true, nil
+			}
+		}
+		return "", "", false, fmt.Errorf("%s contains neither .debs nor a .dsc", path)
+	}
+	return "", "", false, nil
+}
+
+// buildSourceInputs builds all source package arguments (see sourceInput)
+// with sbuild and replaces them with the resulting binary .changes files, so
+// that their .debs are injected when building the reverse dependencies.
+func buildSourceInputs(args []string) []string {
+	var result []string
+	for _, arg := range args {
+		source, dist, ok, err := sourceInput(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			result = append(result, arg)
+			continue
+		}
+		if *dryRun {
+			log.Fatalf("%s needs to be built first to determine its binary packages, which is not possible with -dry_run", arg)
+		}
+
+		if *sbuildDist != "" {
+			dist = *sbuildDist
+		} else if dist == "" || dist == "UNRELEASED" {
+			dist = "unstable"
+		}
+		if dist == "experimental" && !*sbuildExperimentalAspcud {
+			dist = "unstable"
+		}
+		v := lookupVendor(dist)
+		suite := resolveSuite(v, dist)
+		mirror, securityMirror := vendorMirrors(v)
+		builder := &sbuild{
+			dist:              suite.chroot,
+			logDir:            *logDir,
+			keepBuildLog:      *sbuildKeepBuildLog,
+			extraExperimental: dist == "experimental",
+			suite:             suite,
+			mirror:            mirror,
+			securityMirror:    securityMirror,
+		}
+
+		resultDir, err := os.MkdirTemp("", "ratt-target-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		changesFile, logFile, err := builder.buildSource(source, resultDir)
+		if err != nil {
+			log.Fatalf("Building %s failed (see %s): %v", arg, logFile, err)
+		}
+		log.Printf("Built %s, continuing with %s\n", arg, changesFile)
+		result = append(result, changesFile)
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"pault.ag/go/debian/control"
+)
+
+// suggestBreaks returns, for each binary package from the .changes files,
+// the Breaks relations which would mark the binaries of regressed sources
+// (failed with the new packages, but built fine in -recheck) as broken at
+// their archive version. Sources against which the new packages already
+// declare Breaks are left out.
+func suggestBreaks(sources []control.SourceIndex, binaries []string, buildresults map[string]*buildResult) map[string][]string {
+	ours := make(map[string]bool)
+	for _, bin := range binaries {
+		ours[bin] = true
+	}
+	suggestions := make(map[string][]string)
+	for _, src := range sources {
+		result, ok := buildresults[src.Package]
+		if !ok || result.err == nil || !result.rechecked || result.recheckErr != nil || result.expectedBreak != "" {
+			continue
+		}
+		if result.version == nil || src.Version.String() != result.version.String() {
+			continue
+		}
+
+		// Attribute the regression to the binaries the source directly
+		// build-depends on, or to all binaries for indirect dependencies.
+		var culprits []string
+		bd := buildDependsOf(src)
+		for _, p := range bd.GetAllPossibilities() {
+			if ours[p.Name] {
+				culprits = append(culprits, p.Name)
+			}
+		}
+		if len(culprits) == 0 {
+			culprits = binaries
+		}
+
+		for _, culprit := range culprits {
+			for _, bin := range src.Binaries {
+				suggestions[culprit] = append(suggestions[culprit],
+					fmt.Sprintf("%s (<= %s)", strings.TrimSpace(bin), src.Version))
+			}
+		}
+	}
+	for bin, breaks := range suggestions {
+		sort.Strings(breaks)
+		suggestions[bin] = uniq(breaks)
+	}
+	return suggestions
+}
+
+func uniq(sorted []string) []string {
+	var result []string
+	for i, s := range sorted {
+		if i == 0 || sorted[i-1] != s {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// printBreaksSuggestions prints the suggested Breaks as debian/control
+// stanzas to stdout.
+func printBreaksSuggestions(suggestions map[string][]string) {
+	if len(suggestions) == 0 {
+		return
+	}
+	log.Printf("Suggested Breaks for debian/control (packages which only fail with the new version):\n")
+	bins := make([]string, 0, len(suggestions))
+	for bin := range suggestions {
+		bins = append(bins, bin)
+	}
+	sort.Strings(bins)
+	for i, bin := range bins {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Package: %s\n", bin)
+		fmt.Printf("Breaks:\n %s,\n", strings.Join(suggestions[bin], ",\n "))
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// pocket is one archive area (e.g. “bookworm-security”) that contributes
+// packages to a suite.
+type pocket struct {
+	codename string
+	// security pockets are served from the security mirror.
+	security bool
+	// overlay pockets are not part of a plain sbuild chroot, so their binary
+	// packages need to be added via --extra-repository, too.
+	overlay bool
+	// priority, if non-zero, is the apt pin priority for this pocket.
+	priority int
+}
+
+// backportsPriority is the pin priority of backports pockets, which are
+// marked NotAutomatic/ButAutomaticUpgrades in the archive.
+const backportsPriority = 100
+
+// suiteModel describes a build/lookup target as a chain of pockets, for
+// example bookworm-backports = bookworm + bookworm-updates +
+// bookworm-security + bookworm-backports.
+type suiteModel struct {
+	// name is the target as specified by the user or .changes file.
+	name string
+	// chroot is the value for sbuild --dist.
+	chroot string
+	// pockets lists the overlay chain, base suite first.
+	pockets []pocket
+	// components are the archive components used in sources.list entries.
+	components []string
+}
+
+// releasedSuites are the suites which receive -updates and -security pockets.
+var releasedSuites = map[string]bool{
+	"stable":       true,
+	"oldstable":    true,
+	"oldoldstable": true,
+}
+
+// suiteAliases are suite names which need to be resolved to a codename
+// before the pockets can be derived.
+var suiteAliases = map[string]bool{
+	"stable":       true,
+	"oldstable":    true,
+	"oldoldstable": true,
+	"testing":      true,
+}
+
+// resolveSuite builds the suiteModel for target (a suite name, codename or
+// codename with pocket suffix such as “bookworm-backports”) of vendor v.
+func resolveSuite(v *vendor, target string) *suiteModel {
+	if v.name == "debian" {
+		switch target {
+		case "unstable", "sid":
+			return &suiteModel{
+				name:       target,
+				chroot:     target,
+				pockets:    []pocket{{codename: "sid"}},
+				components: v.components,
+			}
+		case "experimental", "rc-buggy":
+			// we build experimental against unstable.
+			return &suiteModel{
+				name:   target,
+				chroot: "unstable",
+				pockets: []pocket{
+					{codename: "sid"},
+					{codename: "rc-buggy", overlay: true},
+				},
+				components: v.components,
+			}
+		}
+	}
+
+	base, suffix := target, ""
+	for _, s := range v.pocketSuffixes {
+		if strings.HasSuffix(target, s) {
+			base, suffix = strings.TrimSuffix(target, s), s
+			break
+		}
+	}
+	chroot := base
+	if suffix == "" {
+		chroot = target
+	}
+
+	if v.name == "debian" && suiteAliases[base] {
+		codename, err := fetchCodenameFromDist(base)
+		if err != nil {
+			log.Printf("Warning: could not resolve codename for %q: %v", base, err)
+		} else {
+			base = codename
+		}
+	}
+
+	model := &suiteModel{
+		name:       target,
+		chroot:     chroot,
+		pockets:    []pocket{{codename: base}},
+		components: v.components,
+	}
+
+	// for released suites, include maintenance pockets (-updates, -security)
+	maintained := v.alwaysMaintained
+	if !maintained {
+		suite, err := codenameToSuite(base)
+		if err != nil {
+			log.Printf("Warning: could not resolve Suite for %q: %v (no -updates/-security overlays)", base, err)
+		}
+		maintained = err == nil && releasedSuites[suite]
+	}
+	if maintained {
+		model.pockets = append(model.pockets,
+			pocket{codename: base + "-updates"},
+			pocket{codename: base + "-security", security: true})
+	}
+
+	switch suffix {
+	case "-backports":
+		model.pockets = append(model.pockets,
+			pocket{codename: base + "-backports", overlay: true, priority: backportsPriority})
+	case "-backports-sloppy":
+		model.pockets = append(model.pockets,
+			pocket{codename: base + "-backports", overlay: true, priority: backportsPriority},
+			pocket{codename: base + "-backports-sloppy", overlay: true, priority: backportsPriority})
+	case "-proposed-updates", "-proposed":
+		model.pockets = append(model.pockets, pocket{codename: target, overlay: true})
+	case "-updates", "-security":
+		if len(model.pockets) == 1 {
+			model.pockets = append(model.pockets, pocket{
+				codename: target,
+				security: suffix == "-security",
+			})
+		}
+	}
+	return model
+}
+
+// codenames returns the codenames of all pockets, as used by apt-get
+// indextargets.
+func (m *suiteModel) codenames() []string {
+	codenames := make([]string, len(m.pockets))
+	for i, p := range m.pockets {
+		codenames[i] = p.codename
+	}
+	return codenames
+}
+
+// extraRepositories returns the sbuild --extra-repository arguments required
+// to make all pockets beyond the base suite available in the chroot.
+func (m *suiteModel) extraRepositories(mirror, securityMirror string) []string {
+	if len(m.pockets) < 2 {
+		return nil
+	}
+	var args []string
+	components := strings.Join(m.components, " ")
+	for _, p := range m.pockets {
+		url := mirror
+		if p.security {
+			url = securityMirror
+		}
+		if p.overlay {
+			args = append(args, "--extra-repository=deb "+url+" "+p.codename+" "+components)
+		}
+		args = append(args, "--extra-repository=deb-src "+url+" "+p.codename+" "+components)
+	}
+	return args
+}
+
+// pinningArgs returns the sbuild arguments which pin pockets with a non-default
+// priority. Like on the backports buildds, the aptitude resolver is used so
+// that build dependencies are only taken from such pockets when required.
+func (m *suiteModel) pinningArgs() []string {
+	var args []string
+	for _, p := range m.pockets {
+		if p.priority == 0 {
+			continue
+		}
+		stanza := fmt.Sprintf(`Package: *\nPin: release n=%s\nPin-Priority: %d\n`, p.codename, p.priority)
+		args = append(args, aptPreferencesArg("ratt-"+p.codename, stanza))
+	}
+	if len(args) > 0 {
+		args = append(args, "--build-dep-resolver=aptitude")
+	}
+	return args
+}
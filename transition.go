@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/version"
+)
+
+var (
+	// libraryStemRe strips the SONAME part (and ABI suffixes such as t64)
+	// from a shared library package name, e.g. libfoo1, libfoo2 and
+	// libfoo1t64 all have the stem libfoo.
+	libraryStemRe = regexp.MustCompile(`^(lib.+?)[-.]?[0-9][0-9.]*(t64|v5|c2)?$`)
+	// libraryStemDigitRe matches library names ending in a digit, for which
+	// policy 8.1 separates the SONAME with a hyphen, e.g. libgtk-3-0 has
+	// the stem libgtk-3 and libglib2.0-0 the stem libglib2.0.
+	libraryStemDigitRe = regexp.MustCompile(`^(lib.*[0-9])-[0-9][0-9.]*(t64|v5|c2)?$`)
+)
+
+func libraryStem(name string) (string, bool) {
+	if m := libraryStemDigitRe.FindStringSubmatch(name); m != nil {
+		return m[1], true
+	}
+	m := libraryStemRe.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// libTransition is a SONAME change of a shared library package, detected by
+// comparing the binaries of the .changes files with the archive.
+type libTransition struct {
+	Old []string
+	New []string
+	// Source is the source package of the library, with its version in the
+	// archive and in the .changes file.
+	Source     string
+	OldVersion string
+	NewVersion string
+}
+
+func (t *libTransition) String() string {
+	return strings.Join(t.Old, ", ") + " -> " + strings.Join(t.New, ", ")
+}
+
+// archiveBinaries returns the binaries the archive lists (Sources Binary:
+// field) for the newest version of each of the given sources, together with
+// the newest versions.
+func archiveBinaries(sources []control.SourceIndex, names []string) (map[string]bool, map[string]version.Version) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	newest := make(map[string]control.SourceIndex)
+	for _, src := range sources {
+		if !wanted[src.Package] {
+			continue
+		}
+		if cur, ok := newest[src.Package]; !ok || version.Compare(src.Version, cur.Version) > 0 {
+			newest[src.Package] = src
+		}
+	}
+	bins := make(map[string]bool)
+	versions := make(map[string]version.Version)
+	for name, src := range newest {
+		versions[name] = src.Version
+		for _, bin := range src.Binaries {
+			bins[strings.TrimSpace(bin)] = true
+		}
+	}
+	return bins, versions
+}
+
+// detectTransition finds library packages which disappear from the sources of
+// the .changes files while a package with the same stem appears. newVersion is
+// the version of the (first) .changes file.
+func detectTransition(sources []control.SourceIndex, changesSources, binaries []string, newVersion string) *libTransition {
+	old, oldVersions := archiveBinaries(sources, changesSources)
+	current := make(map[string]bool)
+	for _, bin := range binaries {
+		current[bin] = true
+	}
+
+	newByStem := make(map[string][]string)
+	for bin := range current {
+		if old[bin] {
+			continue
+		}
+		if stem, ok := libraryStem(bin); ok {
+			newByStem[stem] = append(newByStem[stem], bin)
+		}
+	}
+
+	t := &libTransition{
+		Source:     changesSources[0],
+		NewVersion: newVersion,
+	}
+	if v, ok := oldVersions[t.Source]; ok {
+		t.OldVersion = v.String()
+	}
+	newSeen := make(map[string]bool)
+	for bin := range old {
+		if current[bin] {
+			continue
+		}
+		stem, ok := libraryStem(bin)
+		if !ok || len(newByStem[stem]) == 0 {
+			continue
+		}
+		t.Old = append(t.Old, bin)
+		for _, n := range newByStem[stem] {
+			if !newSeen[n] {
+				newSeen[n] = true
+				t.New = append(t.New, n)
+			}
+		}
+	}
+	if len(t.Old) == 0 {
+		return nil
+	}
+	sort.Strings(t.Old)
+	sort.Strings(t.New)
+	return t
+}
+
+// binNMUCandidates returns the sources (with their versions from the Sources
+// index) which have binaries that depend on any of the old library packages.
+// The sources of the .changes files (changesSources) are left out, as their
+// other binaries (e.g. libfoo-dev) depend on the old library as well.
+func binNMUCandidates(pkgs []binPkg, sources []control.SourceIndex, old, changesSources []string) map[string][]version.Version {
+	oldNames := make(map[string]bool)
+	for _, name := range old {
+		oldNames[name] = true
+	}
+	ours := make(map[string]bool)
+	for _, src := range changesSources {
+		ours[src] = true
+	}
+	affected := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if oldNames[pkg.Package] || ours[pkg.Source] {
+			continue
+		}
+		for _, p := range append(pkg.PreDepends.GetAllPossibilities(), pkg.Depends.GetAllPossibilities()...) {
+			if oldNames[p.Name] {
+				affected[pkg.Source] = true
+				break
+			}
+		}
+	}
+	rebuild := make(map[string][]version.Version)
+	for _, src := range sources {
+		if affected[src.Package] {
+			rebuild[src.Package] = append(rebuild[src.Package], src.Version)
+		}
+	}
+	return rebuild
+}
+
+// binNMUMessage is the changelog entry of the binNMUs.
+func (t *libTransition) binNMUMessage() string {
+	return fmt.Sprintf("Rebuild against %s.", strings.Join(t.New, ", "))
+}
+
+// printTransitionRequest prints the binNMUs in wanna-build syntax, as used in
+// transition bugs filed against release.debian.org. With -json, stdout is
+// reserved for the JSON output and the binNMUs are logged instead.
+func printTransitionRequest(t *libTransition, rebuild map[string][]version.Version, dist string) {
+	log.Printf("Library transition detected: %s\n", t)
+	log.Printf("%d source packages need a binNMU:\n", len(rebuild))
+	srcs := make([]string, 0, len(rebuild))
+	for src := range rebuild {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	for _, src := range srcs {
+		versions := rebuild[src]
+		sort.Sort(sort.Reverse(version.Slice(versions)))
+		nmu := fmt.Sprintf("nmu %s_%s . ANY . %s . -m %q", src, versions[0], dist, t.binNMUMessage())
+		if *jsonOutput {
+			log.Println(nmu)
+		} else {
+			fmt.Println(nmu)
+		}
+	}
+}
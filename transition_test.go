@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
+	"pault.ag/go/debian/version"
+)
+
+func TestLibraryStem(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		stem string
+		ok   bool
+	}{
+		{"libfoo1", "libfoo", true},
+		{"libfoo2", "libfoo", true},
+		{"libfoo1t64", "libfoo", true},
+		{"libfoo-1", "libfoo", true},
+		{"libssl3", "libssl", true},
+		{"libstdc++6", "libstdc++", true},
+		{"libboost-system1.83.0", "libboost-system", true},
+		{"libgtk-3-0", "libgtk-3", true},
+		{"libgtk-3-0t64", "libgtk-3", true},
+		{"libgtk-4-1", "libgtk-4", true},
+		{"libglib2.0-0", "libglib2.0", true},
+		{"libglib2.0-0t64", "libglib2.0", true},
+		{"libfoo-dev", "", false},
+		{"golang-foo-dev", "", false},
+		{"foo1", "", false},
+	} {
+		stem, ok := libraryStem(tt.name)
+		if stem != tt.stem || ok != tt.ok {
+			t.Errorf("libraryStem(%q) = %q, %v, want %q, %v", tt.name, stem, ok, tt.stem, tt.ok)
+		}
+	}
+}
+
+func TestLibraryStemDistinguishesMajorVersions(t *testing.T) {
+	gtk3, _ := libraryStem("libgtk-3-0")
+	gtk4, _ := libraryStem("libgtk-4-1")
+	if gtk3 == gtk4 {
+		t.Errorf("libgtk-3-0 and libgtk-4-1 have the same stem %q", gtk3)
+	}
+}
+
+func TestBinNMUCandidates(t *testing.T) {
+	dep := func(s string) dependency.Dependency {
+		d, err := dependency.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *d
+	}
+	ver := func(s string) version.Version {
+		v, err := version.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	pkgs := []binPkg{
+		{Package: "libfoo1", Source: "foo", Depends: dep("libc6 (>= 2.36)")},
+		// The library's own -dev package depends on the old soname.
+		{Package: "libfoo-dev", Source: "foo", Depends: dep("libfoo1 (= 1.0-1)")},
+		{Package: "bar", Source: "bar", Depends: dep("libc6, libfoo1 (>= 1.0)")},
+		{Package: "baz", Source: "baz", PreDepends: dep("libfoo1")},
+		{Package: "qux", Source: "qux", Depends: dep("libc6")},
+	}
+	sources := []control.SourceIndex{
+		{Package: "foo", Version: ver("1.0-1")},
+		{Package: "bar", Version: ver("2.0-1")},
+		{Package: "baz", Version: ver("3.0-1")},
+		{Package: "qux", Version: ver("4.0-1")},
+	}
+	got := binNMUCandidates(pkgs, sources, []string{"libfoo1"}, []string{"foo"})
+	want := map[string][]version.Version{
+		"bar": {ver("2.0-1")},
+		"baz": {ver("3.0-1")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binNMUCandidates() = %v, want %v", got, want)
+	}
+}
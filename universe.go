@@ -0,0 +1,145 @@
+package main
+
+import (
+	"pault.ag/go/debian/dependency"
+	"pault.ag/go/debian/version"
+)
+
+// universe is a set of binary packages against which dependency relations
+// can be checked. It only looks one level deep, i.e. it checks whether each
+// relation has at least one candidate, not whether the candidates are
+// installable themselves.
+type universe struct {
+	arch dependency.Arch
+	// real maps package names to the packages of that name.
+	real map[string][]binPkg
+	// provided maps virtual package names to the packages providing them.
+	provided map[string][]providedBy
+}
+
+type providedBy struct {
+	pkg binPkg
+	// version is the version of the provided package, if any.
+	version *version.Version
+}
+
+func newUniverse(arch string, pkgs []binPkg) *universe {
+	parsedArch, err := dependency.ParseArch(arch)
+	if err != nil {
+		parsedArch = &dependency.Arch{ABI: "gnu", OS: "linux", CPU: arch}
+	}
+	u := &universe{
+		arch:     *parsedArch,
+		real:     make(map[string][]binPkg),
+		provided: make(map[string][]providedBy),
+	}
+	for _, pkg := range pkgs {
+		u.add(pkg)
+	}
+	return u
+}
+
+func (u *universe) add(pkg binPkg) {
+	u.real[pkg.Package] = append(u.real[pkg.Package], pkg)
+	for _, p := range pkg.Provides.GetAllPossibilities() {
+		pb := providedBy{pkg: pkg}
+		if p.Version != nil && p.Version.Operator == "=" {
+			if v, err := version.Parse(p.Version.Number); err == nil {
+				pb.version = &v
+			}
+		}
+		u.provided[p.Name] = append(u.provided[p.Name], pb)
+	}
+}
+
+// remove drops all packages for which drop returns true.
+func (u *universe) remove(drop func(binPkg) bool) {
+	for name, pkgs := range u.real {
+		var kept []binPkg
+		for _, pkg := range pkgs {
+			if !drop(pkg) {
+				kept = append(kept, pkg)
+			}
+		}
+		u.real[name] = kept
+	}
+	for name, pbs := range u.provided {
+		var kept []providedBy
+		for _, pb := range pbs {
+			if !drop(pb.pkg) {
+				kept = append(kept, pb)
+			}
+		}
+		u.provided[name] = kept
+	}
+}
+
+// clone returns a copy of u which can be modified independently.
+func (u *universe) clone() *universe {
+	c := &universe{
+		arch:     u.arch,
+		real:     make(map[string][]binPkg, len(u.real)),
+		provided: make(map[string][]providedBy, len(u.provided)),
+	}
+	for name, pkgs := range u.real {
+		c.real[name] = append([]binPkg(nil), pkgs...)
+	}
+	for name, pbs := range u.provided {
+		c.provided[name] = append([]providedBy(nil), pbs...)
+	}
+	return c
+}
+
+// candidates returns the packages satisfying possibility p.
+func (u *universe) candidates(p dependency.Possibility) []binPkg {
+	var result []binPkg
+	for _, pkg := range u.real[p.Name] {
+		if p.Version == nil || p.Version.SatisfiedBy(pkg.Version) {
+			result = append(result, pkg)
+		}
+	}
+	for _, pb := range u.provided[p.Name] {
+		if p.Version == nil || (pb.version != nil && p.Version.SatisfiedBy(*pb.version)) {
+			result = append(result, pb.pkg)
+		}
+	}
+	return result
+}
+
+// relevant reports whether p applies to the universe's architecture.
+func (u *universe) relevant(p dependency.Possibility) bool {
+	return p.Architectures == nil || p.Architectures.Matches(&u.arch)
+}
+
+// satisfied reports whether relation r has at least one candidate.
+func (u *universe) satisfied(r dependency.Relation) bool {
+	relevant := false
+	for _, p := range r.Possibilities {
+		if !u.relevant(p) {
+			continue
+		}
+		relevant = true
+		if len(u.candidates(p)) > 0 {
+			return true
+		}
+	}
+	// A relation restricted to other architectures is trivially satisfied.
+	return !relevant
+}
+
+// unsatisfied returns the relations of dep which have no candidate.
+func (u *universe) unsatisfied(dep dependency.Dependency) []dependency.Relation {
+	var result []dependency.Relation
+	for _, r := range dep.Relations {
+		if !u.satisfied(r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// uninstallable returns the Depends/Pre-Depends relations of pkg which
+// cannot be satisfied.
+func (u *universe) uninstallable(pkg binPkg) []dependency.Relation {
+	return append(u.unsatisfied(pkg.PreDepends), u.unsatisfied(pkg.Depends)...)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const (
+	// slowdownFactor and slowdownMin define a significant build time
+	// regression compared to the baseline build.
+	slowdownFactor = 1.5
+	slowdownMin    = time.Minute
+)
+
+// resourceUsage is the resource usage of an sbuild run, including all its
+// (waited-for) child processes.
+type resourceUsage struct {
+	wall time.Duration
+	cpu  time.Duration
+	// maxRSS is the peak RSS of the largest single process of the build
+	// (getrusage(2) does not sum up the process tree), in KiB.
+	maxRSS int64
+}
+
+// runMeasured runs cmd and returns its resource usage, taken from the
+// rusage of the process tree.
+func runMeasured(cmd *exec.Cmd) (resourceUsage, error) {
+	var usage resourceUsage
+	start := time.Now()
+	err := cmd.Run()
+	usage.wall = time.Since(start)
+	if ps := cmd.ProcessState; ps != nil {
+		usage.cpu = ps.UserTime() + ps.SystemTime()
+		if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+			usage.maxRSS = int64(ru.Maxrss)
+		}
+	}
+	return usage, err
+}
+
+func (u resourceUsage) String() string {
+	return fmt.Sprintf("%s, CPU %s, largest process RSS %d MiB",
+		u.wall.Round(time.Second), u.cpu.Round(time.Second), u.maxRSS/1024)
+}
+
+// reference returns the usage r is compared with: that of the baseline
+// build or, without one, that of the previous successful build of the same
+// version from the build time database.
+func (r *buildResult) reference() (*resourceUsage, string) {
+	if r.baselineUsage != nil {
+		return r.baselineUsage, "the baseline build"
+	}
+	if r.previousUsage != nil {
+		return r.previousUsage, "the previous build"
+	}
+	return nil, ""
+}
+
+// slowdown reports whether r took significantly longer than its reference
+// build. CPU time is compared, as with -parallel the wall-clock time of the
+// concurrent builds suffers from contention, while baselines are built one
+// at a time.
+func (r *buildResult) slowdown() bool {
+	ref, _ := r.reference()
+	if r.err != nil || ref == nil {
+		return false
+	}
+	return r.usage.cpu-ref.cpu >= slowdownMin && float64(r.usage.cpu) > slowdownFactor*float64(ref.cpu)
+}
+
+// printSlowdowns logs the packages whose build time regressed compared to
+// the baseline or previous build.
+func printSlowdowns(buildresults map[string]*buildResult) {
+	compared := false
+	for _, src := range sortedKeys(buildresults) {
+		result := buildresults[src]
+		ref, what := result.reference()
+		compared = compared || ref != nil
+		if result.slowdown() {
+			log.Printf("SLOWER: %s took CPU %s instead of %s in %s (wall-clock %s instead of %s)\n", src,
+				result.usage.cpu.Round(time.Second), ref.cpu.Round(time.Second), what,
+				result.usage.wall.Round(time.Second), ref.wall.Round(time.Second))
+		}
+	}
+	if !compared && len(buildresults) > 0 {
+		log.Printf("Build times were not checked for slowdowns: there are no baseline builds (-lintian or -compare-artifacts=baseline) and no previous builds of the same versions in the build time database\n")
+	}
+}
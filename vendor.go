@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"os"
+	"strings"
+)
+
+// vendor describes the archive layout of Debian or a derivative.
+type vendor struct {
+	name           string
+	mirror         string
+	securityMirror string
+	// portsMirror, if non-empty, replaces mirror and securityMirror for
+	// architectures not in primaryArches.
+	portsMirror   string
+	primaryArches map[string]bool
+	components    []string
+	// pocketSuffixes are the pockets layered on top of a series, longest
+	// suffix first.
+	pocketSuffixes []string
+	// alwaysMaintained is set for vendors where every series has -updates and
+	// -security pockets, so no suite lookup is required.
+	alwaysMaintained bool
+	// udd is set for vendors whose FTBFS bugs are tracked on udd.debian.org.
+	udd bool
+}
+
+var vendors = map[string]*vendor{
+	"debian": {
+		name:           "debian",
+		mirror:         "http://deb.debian.org/debian",
+		securityMirror: "http://deb.debian.org/debian-security",
+		components:     []string{"main"},
+		pocketSuffixes: []string{
+			"-backports-sloppy",
+			"-backports",
+			"-proposed-updates",
+			"-updates",
+			"-security",
+		},
+		udd: true,
+	},
+	"ubuntu": {
+		name:           "ubuntu",
+		mirror:         "http://archive.ubuntu.com/ubuntu",
+		securityMirror: "http://security.ubuntu.com/ubuntu",
+		portsMirror:    "http://ports.ubuntu.com/ubuntu-ports",
+		primaryArches:  map[string]bool{"amd64": true, "i386": true},
+		components:     []string{"main", "universe"},
+		pocketSuffixes: []string{
+			"-backports",
+			"-proposed",
+			"-updates",
+			"-security",
+		},
+		alwaysMaintained: true,
+	},
+}
+
+// ubuntuSeriesCSV is shipped by the distro-info-data package.
+const ubuntuSeriesCSV = "/usr/share/distro-info/ubuntu.csv"
+
+// detectVendor guesses the vendor from a .changes Distribution such as
+// “noble-proposed” or “unstable”, defaulting to Debian.
+func detectVendor(distribution string) *vendor {
+	if strings.HasSuffix(distribution, "-proposed") {
+		return vendors["ubuntu"]
+	}
+	series := distribution
+	for _, suffix := range vendors["ubuntu"].pocketSuffixes {
+		series = strings.TrimSuffix(series, suffix)
+	}
+	if isUbuntuSeries(series) {
+		return vendors["ubuntu"]
+	}
+	return vendors["debian"]
+}
+
+func isUbuntuSeries(series string) bool {
+	f, err := os.Open(ubuntuSeriesCSV)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		if len(record) > 2 && record[2] == series {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrors returns the default archive and security mirror for arch.
+func (v *vendor) mirrors(arch string) (mirror, securityMirror string) {
+	if v.portsMirror != "" && !v.primaryArches[arch] {
+		return v.portsMirror, v.portsMirror
+	}
+	return v.mirror, v.securityMirror
+}
+
+// lookupVendor returns the vendor selected via -vendor, or the one detected
+// from distribution.
+func lookupVendor(distribution string) *vendor {
+	if *vendorName == "" {
+		return detectVendor(distribution)
+	}
+	v := vendors[*vendorName]
+	if v == nil {
+		log.Fatalf("Unknown -vendor %q", *vendorName)
+	}
+	return v
+}
+
+// vendorMirrors returns the -mirror and -security-mirror settings, falling
+// back to the defaults of v.
+func vendorMirrors(v *vendor) (string, string) {
+	defaultMirror, defaultSecurityMirror := v.mirrors(buildArch())
+	m, sm := *mirror, *securityMirror
+	if m == "" {
+		m = defaultMirror
+	}
+	if sm == "" {
+		sm = defaultSecurityMirror
+	}
+	return normalizeMirror(m), normalizeMirror(sm)
+}